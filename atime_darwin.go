@@ -0,0 +1,15 @@
+package script
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// accessTime returns the time a file was last accessed.
+func accessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Unix())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package script
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the modification time, the access time of a file is not
+// available on this platform.
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
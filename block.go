@@ -0,0 +1,168 @@
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type blockPlacement int
+
+const (
+	blockAtEnd blockPlacement = iota
+	blockAtStart
+	blockBefore
+	blockAfter
+)
+
+// BlockPosition defines where EnsureBlock inserts a block that is not in the
+// file yet. Existing blocks are updated where they are.
+type BlockPosition struct {
+	placement    blockPlacement
+	searchRegexp string
+}
+
+var (
+	// BlockAtStart inserts a block at the start of the file.
+	BlockAtStart = BlockPosition{placement: blockAtStart}
+	// BlockAtEnd inserts a block at the end of the file.
+	BlockAtEnd = BlockPosition{placement: blockAtEnd}
+)
+
+// BlockBefore inserts a block before the last line matching the regular
+// expression, or at the end of the file if no line matches.
+func BlockBefore(searchRegexp string) BlockPosition {
+	return BlockPosition{placement: blockBefore, searchRegexp: searchRegexp}
+}
+
+// BlockAfter inserts a block after the last line matching the regular
+// expression, or at the end of the file if no line matches.
+func BlockAfter(searchRegexp string) BlockPosition {
+	return BlockPosition{placement: blockAfter, searchRegexp: searchRegexp}
+}
+
+// UnterminatedBlockError is returned if a file contains the begin marker of a
+// block but not its end marker.
+type UnterminatedBlockError struct {
+	Filename string
+	Marker   string
+}
+
+func (e UnterminatedBlockError) Error() string {
+	return fmt.Sprintf("block `%s` in `%s` is not terminated", e.Marker, e.Filename)
+}
+
+// EnsureBlock makes sure a file contains the given content between the lines
+// "# BEGIN marker" and "# END marker", with # replaced by the comment prefix
+// of the file format. If prefix is empty, DefaultCommentPrefix is used. An
+// existing block with the same marker is updated, otherwise the block is
+// inserted at the given position. It returns whether the file was changed.
+func (c *Context) EnsureBlock(filename, marker, content string, position BlockPosition, prefix string) (bool, error) {
+	var re *regexp.Regexp
+	if position.searchRegexp != "" {
+		var err error
+		re, err = regexp.Compile(position.searchRegexp)
+		if err != nil {
+			return false, err
+		}
+	}
+	if prefix == "" {
+		prefix = DefaultCommentPrefix
+	}
+	block := []string{beginMarker(prefix, marker)}
+	if content != "" {
+		block = append(block, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	block = append(block, endMarker(prefix, marker))
+
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		begin, end, ok := findBlock(lines, prefix, marker)
+		if !ok {
+			return nil, false, &UnterminatedBlockError{Filename: c.AbsPath(filename), Marker: marker}
+		}
+		if begin >= 0 {
+			if equalLines(lines[begin:end+1], block) {
+				return lines, false, nil
+			}
+			return replaceLines(lines, begin, end+1, block), true, nil
+		}
+
+		index := len(lines)
+		switch position.placement {
+		case blockAtStart:
+			index = 0
+		case blockBefore, blockAfter:
+			for i, l := range lines {
+				if re.MatchString(l) {
+					index = i
+					if position.placement == blockAfter {
+						index++
+					}
+				}
+			}
+		}
+		return replaceLines(lines, index, index, block), true, nil
+	})
+}
+
+// RemoveBlock removes the block with the given marker and comment prefix
+// created by EnsureBlock from a file. If prefix is empty, DefaultCommentPrefix
+// is used. It returns whether the file was changed.
+func (c *Context) RemoveBlock(filename, marker, prefix string) (bool, error) {
+	if prefix == "" {
+		prefix = DefaultCommentPrefix
+	}
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		begin, end, ok := findBlock(lines, prefix, marker)
+		if !ok {
+			return nil, false, &UnterminatedBlockError{Filename: c.AbsPath(filename), Marker: marker}
+		}
+		if begin < 0 {
+			return lines, false, nil
+		}
+		return replaceLines(lines, begin, end+1, nil), true, nil
+	})
+}
+
+func beginMarker(prefix, marker string) string {
+	return prefix + " BEGIN " + marker
+}
+
+func endMarker(prefix, marker string) string {
+	return prefix + " END " + marker
+}
+
+// findBlock returns the indices of the begin and end marker lines of a block,
+// -1 if there is none. ok is false if the end marker is missing.
+func findBlock(lines []string, prefix, marker string) (begin, end int, ok bool) {
+	begin = -1
+	for i, l := range lines {
+		switch {
+		case begin < 0 && l == beginMarker(prefix, marker):
+			begin = i
+		case begin >= 0 && l == endMarker(prefix, marker):
+			return begin, i, true
+		}
+	}
+	return -1, -1, begin < 0
+}
+
+// replaceLines replaces lines[from:to] with the given replacement.
+func replaceLines(lines []string, from, to int, replacement []string) []string {
+	result := make([]string, 0, len(lines)-(to-from)+len(replacement))
+	result = append(result, lines[:from]...)
+	result = append(result, replacement...)
+	return append(result, lines[to:]...)
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
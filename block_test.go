@@ -0,0 +1,119 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureBlock(t *testing.T) {
+	tests := []struct {
+		content  string
+		position BlockPosition
+		expected string
+	}{
+		{
+			content:  "a\nb\n",
+			position: BlockAtEnd,
+			expected: "a\nb\n# BEGIN deploy\nx\ny\n# END deploy\n",
+		},
+		{
+			content:  "a\nb\n",
+			position: BlockAtStart,
+			expected: "# BEGIN deploy\nx\ny\n# END deploy\na\nb\n",
+		},
+		{
+			content:  "a\nb\na\n",
+			position: BlockBefore(`^a$`),
+			expected: "a\nb\n# BEGIN deploy\nx\ny\n# END deploy\na\n",
+		},
+		{
+			content:  "a\nb\na\n",
+			position: BlockAfter(`^b$`),
+			expected: "a\nb\n# BEGIN deploy\nx\ny\n# END deploy\na\n",
+		},
+		// no match
+		{
+			content:  "a\n",
+			position: BlockAfter(`^c$`),
+			expected: "a\n# BEGIN deploy\nx\ny\n# END deploy\n",
+		},
+		// existing blocks are updated in place
+		{
+			content:  "a\n# BEGIN deploy\nold\n# END deploy\nb\n",
+			position: BlockAtEnd,
+			expected: "a\n# BEGIN deploy\nx\ny\n# END deploy\nb\n",
+		},
+	}
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.cfg"
+
+	for _, test := range tests {
+		afero.WriteFile(sc.Fs(), filename, []byte(test.content), 0644)
+		changed, err := sc.EnsureBlock(filename, "deploy", "x\ny\n", test.position, "")
+		assert.Nil(t, err)
+		assert.True(t, changed)
+		content, _ := afero.ReadFile(sc.Fs(), filename)
+		assert.Equal(t, test.expected, string(content))
+
+		changed, err = sc.EnsureBlock(filename, "deploy", "x\ny", test.position, "")
+		assert.Nil(t, err)
+		assert.False(t, changed)
+	}
+}
+
+func TestEnsureBlockFailure(t *testing.T) {
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.cfg"
+	afero.WriteFile(sc.Fs(), filename, []byte("# BEGIN deploy\nx\n"), 0644)
+
+	_, err := sc.EnsureBlock(filename, "deploy", "y", BlockAtEnd, "")
+	assert.Equal(t, &UnterminatedBlockError{Filename: filename, Marker: "deploy"}, err)
+	_, err = sc.EnsureBlock(filename, "other", "y", BlockAfter(`\p`), "")
+	assert.NotNil(t, err)
+	_, err = sc.EnsureBlock("/etc/missing.cfg", "deploy", "y", BlockAtEnd, "")
+	assert.NotNil(t, err)
+}
+
+func TestRemoveBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.cfg"
+	afero.WriteFile(sc.Fs(), filename, []byte("a\n# BEGIN deploy\nx\n# END deploy\nb\n"), 0644)
+
+	changed, err := sc.RemoveBlock(filename, "deploy", "")
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.RemoveBlock(filename, "deploy", "")
+	assert.Nil(err)
+	assert.False(changed)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("a\nb\n", string(content))
+}
+
+func TestBlockCommentPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.ini"
+	afero.WriteFile(sc.Fs(), filename, []byte("a\n# BEGIN deploy\nx\n# END deploy\n"), 0644)
+
+	changed, err := sc.EnsureBlock(filename, "deploy", "y", BlockAtEnd, ";")
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("a\n# BEGIN deploy\nx\n# END deploy\n; BEGIN deploy\ny\n; END deploy\n", string(content))
+
+	changed, err = sc.RemoveBlock(filename, "deploy", ";")
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ = afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("a\n# BEGIN deploy\nx\n# END deploy\n", string(content))
+}
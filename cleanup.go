@@ -0,0 +1,173 @@
+package script
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// exitFunc is used to end the program, replaceable for tests.
+var exitFunc = os.Exit
+
+// cleanupRegistry keeps track of everything that needs to be cleaned up when
+// the program exits. It is shared by a Context and its clones.
+type cleanupRegistry struct {
+	mu        sync.Mutex
+	funcs     []func()
+	detached  []*ProcessResult
+	tempPaths []tempPath
+	locks     []*Lock
+}
+
+type tempPath struct {
+	fs   afero.Fs
+	path string
+}
+
+func (r *cleanupRegistry) addFunc(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.funcs = append(r.funcs, f)
+}
+
+func (r *cleanupRegistry) addDetached(pr *ProcessResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.detached = append(r.detached, pr)
+}
+
+func (r *cleanupRegistry) removeDetached(pr *ProcessResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.detached {
+		if p == pr {
+			r.detached = append(r.detached[:i], r.detached[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *cleanupRegistry) addTempPath(fs afero.Fs, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tempPaths = append(r.tempPaths, tempPath{fs: fs, path: path})
+}
+
+func (r *cleanupRegistry) addLock(lock *Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.locks = append(r.locks, lock)
+}
+
+func (r *cleanupRegistry) removeLock(lock *Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, l := range r.locks {
+		if l == lock {
+			r.locks = append(r.locks[:i], r.locks[i+1:]...)
+			return
+		}
+	}
+}
+
+// take returns everything registered and resets the registry.
+func (r *cleanupRegistry) take() ([]func(), []*ProcessResult, []tempPath, []*Lock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	funcs, detached, tempPaths, locks := r.funcs, r.detached, r.tempPaths, r.locks
+	r.funcs, r.detached, r.tempPaths, r.locks = nil, nil, nil, nil
+	return funcs, detached, tempPaths, locks
+}
+
+// AddCleanup registers a function to be run by Cleanup. Functions are run in
+// reverse order of their registration, like deferred functions.
+func (c *Context) AddCleanup(f func()) {
+	c.cleanup.addFunc(f)
+}
+
+// Cleanup runs all functions registered using AddCleanup, kills detached
+// processes that are still running, removes the temporary files and
+// directories created using TempFile, TempDir and SetWorkingDirTemp and
+// finally releases the locks acquired using AcquireLock. Clones share their
+// cleanups with the Context they were created from.
+//
+// Cleanup can be called multiple times, everything is cleaned up only once.
+func (c *Context) Cleanup() {
+	funcs, detached, tempPaths, locks := c.cleanup.take()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		runCleanupFunc(funcs[i], c.Stderr())
+	}
+	for _, pr := range detached {
+		killProcess(pr)
+	}
+	for i := len(tempPaths) - 1; i >= 0; i-- {
+		tempPaths[i].fs.RemoveAll(tempPaths[i].path)
+	}
+	for i := len(locks) - 1; i >= 0; i-- {
+		locks[i].release()
+	}
+}
+
+// runCleanupFunc runs a single cleanup function, so that a panic in it does
+// not prevent the others from running.
+func runCleanupFunc(f func(), stderr io.Writer) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(stderr, "%v\n", r)
+		}
+	}()
+	f()
+}
+
+func killProcess(pr *ProcessResult) {
+	if pr.Process == nil {
+		return
+	}
+	// detached processes run in their own process group, kill all of it
+	if pr.Cmd != nil && pr.Cmd.SysProcAttr != nil && pr.Cmd.SysProcAttr.Setpgid {
+		syscall.Kill(-pr.Process.Pid, syscall.SIGKILL)
+		return
+	}
+	pr.Process.Kill()
+}
+
+// TrapSignals makes sure Cleanup is run when the program receives SIGINT or
+// SIGTERM. The program then exits with status 128 + signal number, like a
+// shell does. Call the returned function to stop trapping the signals.
+func (c *Context) TrapSignals() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			c.Cleanup()
+			exitFunc(128 + int(sig.(syscall.Signal)))
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+}
+
+// RecoverAndCleanup is meant to be deferred in main. It runs Cleanup when main
+// returns. If main panics, the panic message is printed to Stderr like
+// RecoverFunc does and the program exits with status 2 after cleaning up.
+func (c *Context) RecoverAndCleanup() {
+	r := recover()
+	c.Cleanup()
+	if r != nil {
+		fmt.Fprintf(c.Stderr(), "%v\n", r)
+		exitFunc(2)
+	}
+}
@@ -0,0 +1,114 @@
+package script
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanup(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	_, stderr := setOutputBuffers(sc)
+
+	order := make([]int, 0)
+	sc.AddCleanup(func() { order = append(order, 1) })
+	sc.Clone().AddCleanup(func() { order = append(order, 2) })
+	sc.AddCleanup(func() { panic("failing cleanup") })
+	sc.AddCleanup(func() { order = append(order, 3) })
+
+	dir, err := sc.TempDir()
+	assert.Nil(err)
+	file, err := sc.tempFileInternal()
+	assert.Nil(err)
+	file.Close()
+
+	sc.Cleanup()
+	assert.Equal([]int{3, 2, 1}, order)
+	assert.False(sc.DirExists(dir))
+	assert.False(sc.FileExists(file.Name()))
+	assert.Equal("failing cleanup\n", stderr.String())
+
+	// only once
+	sc.Cleanup()
+	assert.Equal([]int{3, 2, 1}, order)
+}
+
+func TestCleanupDetached(t *testing.T) {
+	sc := processContext()
+	setOutputBuffers(sc)
+
+	pr, err := sc.ExecuteDetachedFullySilent(interactiveCommand("sleep 10"))
+	assert.Nil(t, err)
+	finished, err := sc.ExecuteDetachedFullySilent(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	sc.WaitCmd(finished)
+
+	start := time.Now()
+	sc.Cleanup()
+	sc.WaitCmd(pr)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	assert.Equal(t, syscall.SIGKILL, pr.Signal())
+}
+
+func TestTrapSignals(t *testing.T) {
+	exitCodes := make(chan int, 1)
+	exitFunc = func(code int) {
+		exitCodes <- code
+	}
+	defer func() {
+		exitFunc = os.Exit
+	}()
+
+	sc := NewContext()
+	cleanedUp := false
+	sc.AddCleanup(func() { cleanedUp = true })
+
+	stop := sc.TrapSignals()
+	defer stop()
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	select {
+	case code := <-exitCodes:
+		assert.Equal(t, 143, code)
+		assert.True(t, cleanedUp)
+	case <-time.After(time.Second):
+		t.Fatal("signal not trapped")
+	}
+}
+
+func TestRecoverAndCleanup(t *testing.T) {
+	exitCode := -1
+	exitFunc = func(code int) {
+		exitCode = code
+	}
+	defer func() {
+		exitFunc = os.Exit
+	}()
+
+	sc := NewContext()
+	_, stderr := setOutputBuffers(sc)
+	cleanups := 0
+	sc.AddCleanup(func() { cleanups++ })
+
+	func() {
+		defer sc.RecoverAndCleanup()
+	}()
+	assert.Equal(t, 1, cleanups)
+	assert.Equal(t, -1, exitCode)
+
+	sc.AddCleanup(func() { cleanups++ })
+	func() {
+		defer sc.RecoverAndCleanup()
+		panic("failed")
+	}()
+	assert.Equal(t, 2, cleanups)
+	assert.Equal(t, 2, exitCode)
+	assert.Equal(t, "failed\n", stderr.String())
+}
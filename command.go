@@ -60,6 +60,8 @@ func isWrapped(source, s string) bool {
 	return strings.HasPrefix(source, s) && strings.HasSuffix(source, s)
 }
 
+// String returns the command line as it would be typed into a shell, with
+// all secrets registered using Context.AddSecret masked.
 func (l *LocalCommand) String() string {
 	var b strings.Builder
 	for i, e := range l.elements {
@@ -78,7 +80,7 @@ func (l *LocalCommand) String() string {
 		}
 		b.WriteString(e)
 	}
-	return b.String()
+	return maskSecrets(allSecrets(), b.String())
 }
 
 // SplitCommand helper splits a string to command and arbitrarily many args.
@@ -1,8 +1,10 @@
 package script
 
 import (
+	"errors"
 	"io"
 	"os"
+	"sync"
 
 	isatty "github.com/mattn/go-isatty"
 	"github.com/spf13/afero"
@@ -11,7 +13,15 @@ import (
 // Context for script operations. A Context includes the working directory and provides
 // access the buffers and results of commands run in the Context.
 // Using different Contexts it is possible to handle multiple separate environments.
+//
+// A Context is safe for concurrent use by multiple goroutines: environment,
+// working dir, secrets and IO streams are guarded, and commands work on a
+// snapshot of them taken when they are started. PushDir, PopDir and WithDir
+// change the working dir for every user of the Context though, so goroutines
+// needing their own working dir should use Clone or WithWorkingDir instead.
+// The filesystem is expected to be configured before the Context is shared.
 type Context struct {
+	mu         sync.RWMutex
 	workingDir string
 	env        map[string]string
 	fs         afero.Fs
@@ -19,18 +29,36 @@ type Context struct {
 	stderr     io.Writer
 	stdin      io.Reader
 	isTTY      bool
+	secrets    []string
+	dirStack   []string
+	outputMu   *sync.Mutex
+	tracer     Tracer
+	timings    *timingLog
+	user       string
+	cleanup    *cleanupRegistry
+	sudoProbes *sudoProbes
+	dryRun     bool
+	args       []string
+	lockDir    string
 }
 
+// ErrDirStackEmpty is returned by PopDir if there is no directory to return to.
+var ErrDirStackEmpty = errors.New("directory stack is empty")
+
 // NewContext returns a pointer to a new Context.
 func NewContext() (context *Context) {
 	// initialize Context
 	context = &Context{
-		env:    make(map[string]string, 0),
-		fs:     afero.NewOsFs(),
-		stdout: os.Stdout,
-		stderr: os.Stderr,
-		stdin:  os.Stdin,
+		env:        make(map[string]string, 0),
+		fs:         afero.NewOsFs(),
+		stderr:     os.Stderr,
+		stdin:      os.Stdin,
+		outputMu:   &sync.Mutex{},
+		timings:    &timingLog{},
+		cleanup:    &cleanupRegistry{},
+		sudoProbes: &sudoProbes{},
 	}
+	context.SetStdout(os.Stdout)
 
 	cwd, err := os.Getwd()
 	if err == nil {
@@ -39,13 +67,76 @@ func NewContext() (context *Context) {
 	return
 }
 
-// SetWorkingDir changes the current working dir
-func (c *Context) SetWorkingDir(workingDir string) {
-	c.workingDir = workingDir
+// SetWorkingDir changes the current working dir. Relative paths are resolved
+// against the current working dir. If the directory does not exist, an error
+// is returned and the working dir is left unchanged.
+func (c *Context) SetWorkingDir(workingDir string) error {
+	dir, err := c.checkDir(workingDir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workingDir = dir
+	return nil
+}
+
+// checkDir returns the absolute path of the given directory or an error if
+// it does not exist.
+func (c *Context) checkDir(workingDir string) (string, error) {
+	dir := c.AbsPath(workingDir)
+	fi, err := c.Fs().Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", &NotADirectoryError{dir}
+	}
+	return dir, nil
+}
+
+// PushDir changes the current working dir like SetWorkingDir and remembers the
+// previous one so it can be restored using PopDir.
+func (c *Context) PushDir(workingDir string) error {
+	dir, err := c.checkDir(workingDir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirStack = append(c.dirStack, c.workingDir)
+	c.workingDir = dir
+	return nil
+}
+
+// PopDir restores the working dir that was active before the last call to PushDir.
+func (c *Context) PopDir() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.dirStack) == 0 {
+		return ErrDirStackEmpty
+	}
+	last := len(c.dirStack) - 1
+	c.workingDir = c.dirStack[last]
+	c.dirStack = c.dirStack[:last]
+	return nil
+}
+
+// WithDir executes f with the working dir set to the given directory. The
+// previous working dir is restored afterwards, even if f panics.
+func (c *Context) WithDir(workingDir string, f func() error) error {
+	err := c.PushDir(workingDir)
+	if err != nil {
+		return err
+	}
+	defer c.PopDir()
+	return f()
 }
 
 // WorkingDir retrieves the current working dir
 func (c *Context) WorkingDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.workingDir
 }
 
@@ -55,8 +146,7 @@ func (c *Context) SetWorkingDirTemp() error {
 	if err != nil {
 		return err
 	}
-	c.SetWorkingDir(dir)
-	return nil
+	return c.SetWorkingDir(dir)
 }
 
 // IsUserRoot checks if a user is root priviledged (Linux and Mac only? Windows?)
@@ -64,7 +154,204 @@ func (c *Context) IsUserRoot() bool {
 	return os.Geteuid() == 0
 }
 
-// IsTerminal returns if this program is run inside an interactive terminal
-func (c Context) IsTerminal() bool {
-	return !(os.Getenv("TERM") == "dumb" || (!isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd())))
+// SetStdout sets the writer all output of this Context is written to.
+func (c *Context) SetStdout(stdout io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stdout = stdout
+	c.isTTY = isTerminalWriter(stdout)
+}
+
+// Stdout returns the writer all output of this Context is written to.
+func (c *Context) Stdout() io.Writer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stdout
+}
+
+// SetStderr sets the writer all error output of this Context is written to.
+func (c *Context) SetStderr(stderr io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stderr = stderr
+}
+
+// Stderr returns the writer all error output of this Context is written to.
+func (c *Context) Stderr() io.Writer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stderr
+}
+
+// SetStdin sets the reader commands executed in this Context read their input from.
+func (c *Context) SetStdin(stdin io.Reader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stdin = stdin
+}
+
+// Stdin returns the reader commands executed in this Context read their input from.
+func (c *Context) Stdin() io.Reader {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.stdin
+}
+
+// IsTerminal returns if the output of this Context is written to an
+// interactive terminal. The detection can be overridden by setting the
+// environment variable FORCE_TTY or NO_TTY (in the Context or the process).
+func (c *Context) IsTerminal() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.isEnvFlagSet("FORCE_TTY") {
+		return true
+	}
+	if c.isEnvFlagSet("NO_TTY") || c.lookupEnv("TERM") == "dumb" {
+		return false
+	}
+	return c.isTTY
+}
+
+// lookupEnv returns the value of an environment variable, preferring the
+// Context's own environment. The caller must hold the lock.
+func (c *Context) lookupEnv(key string) string {
+	if value, ok := c.env[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+func (c *Context) isEnvFlagSet(key string) bool {
+	value := c.lookupEnv(key)
+	return value != "" && value != "0" && value != "false"
+}
+
+// syncWriter makes sure concurrently running commands do not write to a shared
+// writer at the same time. Files are safe for concurrent use and handed to
+// commands directly.
+func (c *Context) syncWriter(w io.Writer) io.Writer {
+	if _, ok := w.(*os.File); ok {
+		return w
+	}
+	return &syncedWriter{mu: c.outputMu, w: w}
+}
+
+type syncedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncedWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func isTerminalWriter(w io.Writer) bool {
+	f, ok := w.(interface{ Fd() uintptr })
+	if !ok {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// Clone returns a copy of this Context. Changes to the settings of the copy
+// (working dir, directory stack, environment, secrets, user, dry run mode and
+// the writers, filesystem and Tracer used) do not affect the original and vice
+// versa. These are shared with the original on purpose:
+//
+//   - cleanup functions and running detached processes, so Cleanup of either
+//     Context handles everything registered on both
+//   - the timings of executed commands, see WriteTimingReport
+//   - whether sudo allows running commands as another user, see AsUser
+//   - the lock serializing output written to the same writers
+//   - the filesystem, writers and Tracer themselves, which are not copied
+func (c *Context) Clone() *Context {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	clone := &Context{
+		workingDir: c.workingDir,
+		env:        make(map[string]string, len(c.env)),
+		fs:         c.fs,
+		stdout:     c.stdout,
+		stderr:     c.stderr,
+		stdin:      c.stdin,
+		isTTY:      c.isTTY,
+		secrets:    append([]string{}, c.secrets...),
+		dirStack:   append([]string{}, c.dirStack...),
+		outputMu:   c.outputMu,
+		tracer:     c.tracer,
+		timings:    c.timings,
+		user:       c.user,
+		cleanup:    c.cleanup,
+		sudoProbes: c.sudoProbes,
+		dryRun:     c.dryRun,
+		args:       append([]string{}, c.args...),
+		lockDir:    c.lockDir,
+	}
+	for key, value := range c.env {
+		clone.env[key] = value
+	}
+	return clone
+}
+
+// WithEnv returns a copy of this Context with the given environment variable set.
+func (c *Context) WithEnv(key, value string) *Context {
+	clone := c.Clone()
+	clone.SetEnv(key, value)
+	return clone
+}
+
+// SetFs sets the filesystem all file operations of this Context work on.
+// It defaults to afero.NewOsFs(), use afero.NewMemMapFs() for tests.
+func (c *Context) SetFs(fs afero.Fs) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fs = fs
+}
+
+// Fs returns the filesystem of this Context.
+func (c *Context) Fs() afero.Fs {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fs
+}
+
+// WithFs returns a copy of this Context using the given filesystem.
+func (c *Context) WithFs(fs afero.Fs) *Context {
+	clone := c.Clone()
+	clone.SetFs(fs)
+	return clone
+}
+
+// WithStdout returns a copy of this Context writing output to the given writer.
+func (c *Context) WithStdout(stdout io.Writer) *Context {
+	clone := c.Clone()
+	clone.SetStdout(stdout)
+	return clone
+}
+
+// WithStderr returns a copy of this Context writing error output to the given writer.
+func (c *Context) WithStderr(stderr io.Writer) *Context {
+	clone := c.Clone()
+	clone.SetStderr(stderr)
+	return clone
+}
+
+// WithStdin returns a copy of this Context reading input from the given reader.
+func (c *Context) WithStdin(stdin io.Reader) *Context {
+	clone := c.Clone()
+	clone.SetStdin(stdin)
+	return clone
+}
+
+// WithWorkingDir returns a copy of this Context using the given working dir.
+// See SetWorkingDir for details on how the directory is validated.
+func (c *Context) WithWorkingDir(workingDir string) (*Context, error) {
+	clone := c.Clone()
+	err := clone.SetWorkingDir(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	return clone, nil
 }
@@ -1,17 +1,91 @@
 package script
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestWorkingDir(t *testing.T) {
 	workingDir := "/tmp/working-dir"
 	sc := NewContext()
-	sc.SetWorkingDir(workingDir)
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, workingDir)
+	err := sc.SetWorkingDir(workingDir)
+	assert.Nil(t, err)
 	assert.Equal(t, workingDir, sc.WorkingDir(), fmt.Sprintf("Expected working directory not set (should be %s)", workingDir))
+
+	// relative to the current working dir
+	makeDirectory(sc, "/tmp/working-dir/sub")
+	err = sc.SetWorkingDir("sub/")
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/working-dir/sub", sc.WorkingDir())
+}
+
+func TestWorkingDirFailure(t *testing.T) {
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/existing")
+	makeFile(sc, "/existing/file", "")
+	assert.Nil(t, sc.SetWorkingDir("/existing"))
+
+	err := sc.SetWorkingDir("/not-existing")
+	assert.NotNil(t, err)
+	err = sc.SetWorkingDir("file")
+	assert.IsType(t, &NotADirectoryError{}, err)
+	assert.Equal(t, "/existing", sc.WorkingDir())
+}
+
+func TestPushPopDir(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/a/b")
+	sc.SetWorkingDir("/a")
+
+	assert.Nil(sc.PushDir("b"))
+	assert.Equal("/a/b", sc.WorkingDir())
+	assert.NotNil(sc.PushDir("/not-existing"))
+	assert.Equal("/a/b", sc.WorkingDir())
+	assert.Nil(sc.PopDir())
+	assert.Equal("/a", sc.WorkingDir())
+	assert.Equal(ErrDirStackEmpty, sc.PopDir())
+}
+
+func TestWithDir(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/a/b")
+	sc.SetWorkingDir("/a")
+
+	myErr := errors.New("failed")
+	err := sc.WithDir("b", func() error {
+		assert.Equal("/a/b", sc.WorkingDir())
+		return myErr
+	})
+	assert.Equal(myErr, err)
+	assert.Equal("/a", sc.WorkingDir())
+
+	assert.Panics(func() {
+		sc.WithDir("b", func() error {
+			panic("oops")
+		})
+	})
+	assert.Equal("/a", sc.WorkingDir())
+
+	err = sc.WithDir("/not-existing", func() error {
+		return nil
+	})
+	assert.NotNil(err)
 }
 
 func TestIsUserRoot(t *testing.T) {
@@ -32,3 +106,99 @@ func TestSetWorkingDirTemp(t *testing.T) {
 
 	assert.NotEqual(wd1, wd2)
 }
+
+func TestClone(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/a/b")
+	sc.SetWorkingDir("/a")
+	sc.SetEnv("KEY", "parent")
+
+	clone := sc.Clone()
+	clone.SetEnv("KEY", "child")
+	clone.SetEnv("OTHER", "value")
+	clone.AddSecret("secret")
+	clone.PushDir("b")
+
+	assert.Equal("parent", sc.GetCustomEnvValue("KEY"))
+	assert.Empty(sc.GetCustomEnvValue("OTHER"))
+	assert.Equal("secret", sc.MaskSecrets("secret"))
+	assert.Equal("/a", sc.WorkingDir())
+	assert.Equal(ErrDirStackEmpty, sc.PopDir())
+
+	assert.Equal("child", clone.GetCustomEnvValue("KEY"))
+	assert.Equal("/a/b", clone.WorkingDir())
+
+	sc.AddSecret("parent")
+	assert.Equal("parent", clone.MaskSecrets("parent"))
+
+	// cleanup is shared
+	cleaned := false
+	clone.AddCleanup(func() { cleaned = true })
+	sc.Cleanup()
+	assert.True(cleaned)
+}
+
+func TestDerivedContexts(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	fs := afero.NewMemMapFs()
+	fs.MkdirAll("/a/b", 0700)
+
+	child := sc.WithFs(fs)
+	assert.Equal(fs, child.fs)
+	assert.NotEqual(fs, sc.fs)
+
+	child = sc.WithEnv("KEY", "value")
+	assert.Equal("value", child.GetCustomEnvValue("KEY"))
+	assert.Empty(sc.GetCustomEnvValue("KEY"))
+
+	var stdout, stderr bytes.Buffer
+	child = sc.WithStdout(&stdout).WithStderr(&stderr).WithStdin(strings.NewReader("input"))
+	assert.Equal(&stdout, child.stdout)
+	assert.Equal(&stderr, child.stderr)
+	assert.Equal(os.Stdout, sc.stdout)
+
+	sc.fs = fs
+	sc.SetWorkingDir("/a")
+	child, err := sc.WithWorkingDir("b")
+	assert.Nil(err)
+	assert.Equal("/a/b", child.WorkingDir())
+	assert.Equal("/a", sc.WorkingDir())
+	_, err = sc.WithWorkingDir("/not-existing")
+	assert.NotNil(err)
+}
+
+func TestIsTerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	setOutputBuffers(sc)
+	assert.False(sc.IsTerminal())
+
+	sc.SetEnv("FORCE_TTY", "1")
+	assert.True(sc.IsTerminal())
+	sc.SetEnv("FORCE_TTY", "")
+	sc.SetEnv("NO_TTY", "1")
+	assert.False(sc.IsTerminal())
+}
+
+func TestStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	assert.Equal(os.Stdout, sc.Stdout())
+	assert.Equal(os.Stderr, sc.Stderr())
+	assert.Equal(os.Stdin, sc.Stdin())
+
+	stdout, stderr := setOutputBuffers(sc)
+	stdin := strings.NewReader("")
+	sc.SetStdin(stdin)
+	assert.Equal(stdout, sc.Stdout())
+	assert.Equal(stderr, sc.Stderr())
+	assert.Equal(stdin, sc.Stdin())
+}
@@ -7,14 +7,25 @@ import (
 
 // SetEnv sets a certain environment variable for this context
 func (c *Context) SetEnv(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.env[key] = value
 }
 
 func (c *Context) GetCustomEnvValue(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.env[key]
 }
 
 func (c *Context) GetCustomEnv() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.customEnv()
+}
+
+// customEnv returns the Context's own environment. The caller must hold the lock.
+func (c *Context) customEnv() []string {
 	env := make([]string, 0)
 	for key, value := range c.env {
 		env = append(env, fmt.Sprintf("%s=%s", key, value))
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	script "github.com/jojomi/go-script/v2"
+)
+
+// try running with --verbose or --dry-run (which skips commands only, files
+// written by the script would still be changed)
+func main() {
+	script.Main(func(sc *script.Context) error {
+		pr, err := sc.ExecuteSilent(script.LocalCommandFrom("date -R"))
+		if err != nil {
+			return err
+		}
+		fmt.Print("The current date: ", pr.Output())
+
+		pr, err = sc.ExecuteSilent(script.LocalCommandFrom("ls /not-existing"))
+		if err != nil {
+			return err
+		}
+		return pr.Check()
+	})
+}
@@ -0,0 +1,184 @@
+package script
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrExpectTimeout is returned by Expect if the output did not match in time.
+	ErrExpectTimeout = errors.New("timeout waiting for expected output")
+	// ErrExpectEOF is returned by Expect if the process finished without the
+	// output matching.
+	ErrExpectEOF = errors.New("process finished without expected output")
+	// ErrDryRunSpawn is returned by Spawn in dry run mode, interactions with
+	// processes can not be simulated.
+	ErrDryRunSpawn = errors.New("interactive commands are not run in dry run mode")
+)
+
+// Interaction is a running process that can be automated expect-style by
+// waiting for certain output and sending input in return.
+type Interaction struct {
+	c      *Context
+	pr     *ProcessResult
+	stdin  io.WriteCloser
+	mu     sync.Mutex
+	output []byte
+	offset int
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// Spawn starts a system command for interaction. Its stdin is connected to
+// Send, stdout and stderr are captured and handled according to the given
+// CommandConfig as usual, RawStdout, RawStderr, ConnectStdin and Detach are
+// ignored. In dry run mode the command is only reported to the Tracer and
+// ErrDryRunSpawn is returned.
+func (c *Context) Spawn(cc CommandConfig, command Command) (*Interaction, error) {
+	cc.RawStdout = false
+	cc.RawStderr = false
+	cc.ConnectStdin = false
+	if c.DryRun() {
+		c.dryRunResult(command)
+		return nil, ErrDryRunSpawn
+	}
+	cmd, pr, err := c.prepareCommand(cc, command)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Interaction{
+		c:      c,
+		pr:     pr,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	i.stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, writerFunc(i.collect))
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, writerFunc(i.collect))
+
+	err = c.startCommand(cmd, pr)
+	if err != nil {
+		return nil, err
+	}
+	c.cleanup.addDetached(pr)
+	go func() {
+		c.WaitCmd(pr)
+		close(i.done)
+	}()
+	return i, nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// collect stores the combined output of the process.
+func (i *Interaction) collect(p []byte) (int, error) {
+	i.mu.Lock()
+	i.output = append(i.output, p...)
+	i.mu.Unlock()
+	select {
+	case i.notify <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// Expect waits until the output (stdout and stderr combined) of the process
+// written since the last match matches the given regular expression. It
+// returns the match followed by its submatches.
+//
+// Stdout and stderr are read independently, so the order of output written to
+// both streams in quick succession is not guaranteed.
+func (i *Interaction) Expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if match := i.match(re); match != nil {
+			return match, nil
+		}
+		select {
+		case <-i.notify:
+		case <-i.done:
+			// all output has been collected when the process is done
+			if match := i.match(re); match != nil {
+				return match, nil
+			}
+			return nil, ErrExpectEOF
+		case <-timer.C:
+			return nil, ErrExpectTimeout
+		}
+	}
+}
+
+// ExpectString waits until the output of the process written since the last
+// match contains the given string. See Expect for details.
+func (i *Interaction) ExpectString(s string, timeout time.Duration) error {
+	_, err := i.Expect(regexp.MustCompile(regexp.QuoteMeta(s)), timeout)
+	return err
+}
+
+func (i *Interaction) match(re *regexp.Regexp) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	loc := re.FindSubmatchIndex(i.output[i.offset:])
+	if loc == nil {
+		return nil
+	}
+	match := make([]string, len(loc)/2)
+	for n := range match {
+		if loc[2*n] >= 0 {
+			match[n] = string(i.output[i.offset+loc[2*n] : i.offset+loc[2*n+1]])
+		}
+	}
+	i.offset += loc[1]
+	return maskAll(i.pr.secrets, match)
+}
+
+// Send writes the given text to the stdin of the process.
+func (i *Interaction) Send(text string) error {
+	_, err := io.WriteString(i.stdin, text)
+	return i.c.maskError(err)
+}
+
+// SendLine writes the given text followed by a newline to the stdin of the process.
+func (i *Interaction) SendLine(text string) error {
+	return i.Send(text + "\n")
+}
+
+// CloseStdin closes the stdin of the process, signalling the end of input.
+func (i *Interaction) CloseStdin() error {
+	err := i.stdin.Close()
+	// closed when the process finished already
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Kill kills the process.
+func (i *Interaction) Kill() error {
+	return i.pr.Process.Kill()
+}
+
+// Wait waits for the process to finish and returns its ProcessResult.
+func (i *Interaction) Wait() *ProcessResult {
+	<-i.done
+	return i.pr
+}
+
+// ProcessResult returns the ProcessResult of the process, which is complete
+// only after Wait returned.
+func (i *Interaction) ProcessResult() *ProcessResult {
+	return i.pr
+}
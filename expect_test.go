@@ -0,0 +1,84 @@
+package script
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func interactiveCommand(script string) Command {
+	c := NewLocalCommand()
+	c.AddAll("/bin/sh", "-c", script)
+	return c
+}
+
+func TestExpect(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	i, err := sc.Spawn(CommandConfig{}, interactiveCommand(`printf "Name: "; read name; echo "Hello $name"; printf "Age: "; read age; echo "$name is $age" >&2`))
+	assert.Nil(err)
+
+	_, err = i.Expect(regexp.MustCompile(`Name: $`), time.Second)
+	assert.Nil(err)
+	assert.Nil(i.SendLine("Joe"))
+	match, err := i.Expect(regexp.MustCompile(`Hello (\w+)`), time.Second)
+	assert.Nil(err)
+	assert.Equal([]string{"Hello Joe", "Joe"}, match)
+
+	assert.Nil(i.ExpectString("Age:", time.Second))
+	assert.Nil(i.SendLine("42"))
+	assert.Nil(i.ExpectString("Joe is 42", time.Second))
+
+	pr := i.Wait()
+	assert.True(pr.Successful())
+	assert.Equal("Name: Hello Joe\nAge: ", pr.Output())
+	assert.Equal("Joe is 42\n", pr.Error())
+	assert.Equal(pr, i.ProcessResult())
+}
+
+func TestExpectTimeout(t *testing.T) {
+	sc := processContext()
+	i, err := sc.Spawn(CommandConfig{}, interactiveCommand(`read answer`))
+	assert.Nil(t, err)
+
+	_, err = i.Expect(regexp.MustCompile(`never`), 50*time.Millisecond)
+	assert.Equal(t, ErrExpectTimeout, err)
+	assert.Nil(t, i.Kill())
+	assert.False(t, i.Wait().Successful())
+}
+
+func TestExpectEOF(t *testing.T) {
+	sc := processContext()
+	i, err := sc.Spawn(CommandConfig{}, LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, i.ExpectString("whatever", time.Second))
+	assert.Nil(t, i.CloseStdin())
+	_, err = i.Expect(regexp.MustCompile(`hello`), time.Second)
+	assert.Equal(t, ErrExpectEOF, err)
+}
+
+func TestSpawnFailure(t *testing.T) {
+	sc := processContext()
+	_, err := sc.Spawn(CommandConfig{}, LocalCommandFrom(nonExistingBinary))
+	assert.NotNil(t, err)
+}
+
+func TestSpawnDryRun(t *testing.T) {
+	sc := processContext()
+	sc.SetDryRun(true)
+	events := make([]TraceEvent, 0)
+	sc.SetTracer(TracerFunc(func(event TraceEvent) {
+		events = append(events, event)
+	}))
+
+	i, err := sc.Spawn(CommandConfig{}, interactiveCommand("touch spawned"))
+	assert.Nil(t, i)
+	assert.Equal(t, ErrDryRunSpawn, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, TraceExecStart, events[0].Op)
+	assert.False(t, sc.FileExists("spawned"))
+}
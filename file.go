@@ -1,47 +1,159 @@
 package script
 
 import (
-	"io/ioutil"
+	"errors"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
 )
 
-func (c Context) ReplaceInFile(filename, searchRegexp, replacement string) error {
+// BackupSuffix is appended to the filename of backups created by
+// WriteFileAtomicOpts.
+const BackupSuffix = ".bak"
+
+// WriteFileOptions defines details of writing a file.
+type WriteFileOptions struct {
+	// Perm is used if the file does not exist yet, otherwise the mode and
+	// ownership of the existing file are kept.
+	Perm os.FileMode
+	// Backup keeps a copy of the previous content of the file next to it,
+	// with BackupSuffix appended to its name.
+	Backup bool
+}
+
+// WriteFileAtomic writes data to a file, which is created with the given
+// permissions if it does not exist yet. The data is written to a temporary
+// file in the same directory first, which then replaces the file, so readers
+// see either the old or the new content but never a partially written file,
+// even if the program crashes. Mode and ownership of an existing file are kept.
+func (c *Context) WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return c.WriteFileAtomicOpts(filename, data, WriteFileOptions{Perm: perm})
+}
+
+// WriteFileAtomicOpts is a variant of WriteFileAtomic that takes options.
+func (c *Context) WriteFileAtomicOpts(filename string, data []byte, options WriteFileOptions) (err error) {
 	absoluteFilename := c.AbsPath(filename)
+	defer c.traceOp(TraceWriteFile, time.Now(), &err, absoluteFilename)
+	return c.writeFileAtomic(absoluteFilename, data, options)
+}
 
-	// read file to string
-	b, err := ioutil.ReadFile(absoluteFilename)
-	if err != nil {
+func (c *Context) writeFileAtomic(filename string, data []byte, options WriteFileOptions) (err error) {
+	fs := c.Fs()
+	// replace the target of a symlink, not the symlink itself
+	if reader, ok := fs.(afero.LinkReader); ok {
+		if resolved, err := evalSymlinks(fs, reader, filename); err == nil {
+			filename = resolved
+		}
+	}
+
+	mode := options.Perm
+	info, err := fs.Stat(filename)
+	switch {
+	case err == nil:
+		mode = info.Mode()
+		if options.Backup {
+			old, err := afero.ReadFile(fs, filename)
+			if err != nil {
+				return err
+			}
+			err = c.writeFileAtomic(filename+BackupSuffix, old, WriteFileOptions{Perm: mode})
+			if err != nil {
+				return err
+			}
+		}
+	case !os.IsNotExist(err):
 		return err
 	}
 
-	// replace
-	re, err := regexp.Compile(searchRegexp)
+	dir, base := filepath.Split(filename)
+	tmp, err := afero.TempFile(fs, dir, "."+base+".tmp")
 	if err != nil {
 		return err
 	}
-	b = re.ReplaceAll(b, []byte(replacement))
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			fs.Remove(tmp.Name())
+		}
+	}()
 
-	// write back
-	file, err := os.Open(absoluteFilename)
+	_, err = tmp.Write(data)
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
 	if err != nil {
 		return err
 	}
-	fileInfo, err := file.Stat()
+	err = fs.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		err = chownLike(fs, tmp.Name(), info)
+		if err != nil {
+			return err
+		}
+	}
+	err = tmp.Close()
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(absoluteFilename, b, fileInfo.Mode())
+	err = fs.Rename(tmp.Name(), filename)
 	if err != nil {
 		return err
 	}
+
+	// persist the rename, not supported by every filesystem
+	if d, err := fs.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 	return nil
 }
 
+// chownLike gives the file name the owner and group of the file described by
+// info if they differ. Regular users can not give files away, in that case the
+// file is kept as is.
+func chownLike(fs afero.Fs, name string, info os.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	current, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if have, ok := current.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	err = fs.Chown(name, int(want.Uid), int(want.Gid))
+	if errors.Is(err, syscall.EPERM) && geteuid() != 0 {
+		return nil
+	}
+	return err
+}
+
+// ReplaceInFile replaces all matches of a regular expression in a file. The
+// file is written using WriteFileAtomic. See ReplaceInFileOpts for more
+// options.
+func (c *Context) ReplaceInFile(filename, searchRegexp, replacement string) error {
+	_, err := c.ReplaceInFileOpts(filename, ReplaceOptions{
+		Replacements: []Replacement{
+			{Search: searchRegexp, Replace: replacement},
+		},
+	})
+	return err
+}
+
 // FileHasContent func
-func (c Context) FileHasContent(filename, search string) (bool, error) {
-	fileContents, err := ioutil.ReadFile(c.AbsPath(filename))
+func (c *Context) FileHasContent(filename, search string) (bool, error) {
+	fileContents, err := afero.ReadFile(c.Fs(), c.AbsPath(filename))
 	if err != nil {
 		return false, err
 	}
@@ -49,8 +161,8 @@ func (c Context) FileHasContent(filename, search string) (bool, error) {
 }
 
 // FileHasContentRegexp func
-func (c Context) FileHasContentRegexp(filename, searchRegexp string) (bool, error) {
-	fileContents, err := ioutil.ReadFile(c.AbsPath(filename))
+func (c *Context) FileHasContentRegexp(filename, searchRegexp string) (bool, error) {
+	fileContents, err := afero.ReadFile(c.Fs(), c.AbsPath(filename))
 	if err != nil {
 		return false, err
 	}
@@ -62,10 +174,154 @@ func (c Context) FileHasContentRegexp(filename, searchRegexp string) (bool, erro
 	return len(results) > 0, nil
 }
 
-/*func (c Context) FileComment(filename, searchRegexp string) {
+// DefaultCommentPrefix is used by CommentLines, UncommentLines, EnsureBlock and
+// RemoveBlock if no prefix is given.
+const DefaultCommentPrefix = "#"
 
+// EnsureLine makes sure a file contains the given line, appending it if
+// needed. It returns whether the file was changed.
+func (c *Context) EnsureLine(filename, line string) (bool, error) {
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		for _, l := range lines {
+			if l == line {
+				return lines, false, nil
+			}
+		}
+		return append(lines, line), true, nil
+	})
 }
 
-func (c Context) FileUncomment(filename, searchRegexp string) {
+// EnsureLineRegexp makes sure a file contains the given line. If it does not,
+// the last line matching the regular expression is replaced by it. If no line
+// matches, the line is appended. It returns whether the file was changed.
+func (c *Context) EnsureLineRegexp(filename, searchRegexp, line string) (bool, error) {
+	re, err := regexp.Compile(searchRegexp)
+	if err != nil {
+		return false, err
+	}
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		match := -1
+		for i, l := range lines {
+			if l == line {
+				return lines, false, nil
+			}
+			if re.MatchString(l) {
+				match = i
+			}
+		}
+		if match == -1 {
+			return append(lines, line), true, nil
+		}
+		lines[match] = line
+		return lines, true, nil
+	})
+}
 
-}*/
+// RemoveLines removes all lines matching the regular expression from a file.
+// It returns whether the file was changed.
+func (c *Context) RemoveLines(filename, searchRegexp string) (bool, error) {
+	re, err := regexp.Compile(searchRegexp)
+	if err != nil {
+		return false, err
+	}
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		result := lines[:0]
+		for _, l := range lines {
+			if !re.MatchString(l) {
+				result = append(result, l)
+			}
+		}
+		return result, len(result) != len(lines), nil
+	})
+}
+
+// CommentLines comments out all lines matching the regular expression by
+// adding the prefix in front of them, after any indentation. Lines that are
+// commented out already are left untouched. If prefix is empty,
+// DefaultCommentPrefix is used. It returns whether the file was changed.
+func (c *Context) CommentLines(filename, searchRegexp, prefix string) (bool, error) {
+	re, err := regexp.Compile(searchRegexp)
+	if err != nil {
+		return false, err
+	}
+	if prefix == "" {
+		prefix = DefaultCommentPrefix
+	}
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		changed := false
+		for i, l := range lines {
+			indent, content := splitIndent(l)
+			if strings.HasPrefix(content, prefix) || !re.MatchString(content) {
+				continue
+			}
+			lines[i] = indent + prefix + content
+			changed = true
+		}
+		return lines, changed, nil
+	})
+}
+
+// UncommentLines is the reverse of CommentLines. It removes the prefix and the
+// whitespace following it from all commented out lines whose content matches
+// the regular expression. If prefix is empty, DefaultCommentPrefix is used. It
+// returns whether the file was changed.
+func (c *Context) UncommentLines(filename, searchRegexp, prefix string) (bool, error) {
+	re, err := regexp.Compile(searchRegexp)
+	if err != nil {
+		return false, err
+	}
+	if prefix == "" {
+		prefix = DefaultCommentPrefix
+	}
+	return c.editLines(filename, func(lines []string) ([]string, bool, error) {
+		changed := false
+		for i, l := range lines {
+			indent, content := splitIndent(l)
+			if !strings.HasPrefix(content, prefix) {
+				continue
+			}
+			content = strings.TrimLeft(strings.TrimPrefix(content, prefix), " \t")
+			if !re.MatchString(content) {
+				continue
+			}
+			lines[i] = indent + content
+			changed = true
+		}
+		return lines, changed, nil
+	})
+}
+
+// editLines applies edit to the lines of a file and writes the file only if
+// edit reports a change and no error.
+func (c *Context) editLines(filename string, edit func(lines []string) ([]string, bool, error)) (changed bool, err error) {
+	absoluteFilename := c.AbsPath(filename)
+	defer c.traceOp(TraceEditLines, time.Now(), &err, absoluteFilename)
+
+	b, err := afero.ReadFile(c.Fs(), absoluteFilename)
+	if err != nil {
+		return false, err
+	}
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	lines, changed, err = edit(lines)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	err = c.writeFileAtomic(absoluteFilename, []byte(content), WriteFileOptions{})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func splitIndent(line string) (indent, content string) {
+	content = strings.TrimLeft(line, " \t")
+	return line[:len(line)-len(content)], content
+}
@@ -3,8 +3,11 @@ package script
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -87,3 +90,227 @@ func TestReplaceInFile(t *testing.T) {
 		os.Remove(filename)
 	}
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetWorkingDir(dir)
+
+	// new file
+	assert.Nil(sc.WriteFileAtomic("config.yml", []byte("a: 1\n"), 0600))
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal("a: 1\n", string(content))
+	info, _ := os.Stat(filepath.Join(dir, "config.yml"))
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// existing file keeps its mode
+	os.Chmod(filepath.Join(dir, "config.yml"), 0640)
+	assert.Nil(sc.WriteFileAtomic("config.yml", []byte("a: 2\n"), 0600))
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal("a: 2\n", string(content))
+	info, _ = os.Stat(filepath.Join(dir, "config.yml"))
+	assert.Equal(os.FileMode(0640), info.Mode().Perm())
+
+	// backup
+	assert.Nil(sc.WriteFileAtomicOpts("config.yml", []byte("a: 3\n"), WriteFileOptions{Backup: true}))
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal("a: 3\n", string(content))
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "config.yml.bak"))
+	assert.Equal("a: 2\n", string(content))
+
+	// symlinks are kept, their target is written
+	os.Symlink("config.yml", filepath.Join(dir, "link.yml"))
+	assert.Nil(sc.WriteFileAtomic("link.yml", []byte("a: 4\n"), 0600))
+	target, err := os.Readlink(filepath.Join(dir, "link.yml"))
+	assert.Nil(err)
+	assert.Equal("config.yml", target)
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal("a: 4\n", string(content))
+
+	// no temporary files are left
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Len(entries, 3)
+
+	assert.NotNil(sc.WriteFileAtomic("missing/config.yml", []byte("a: 1\n"), 0600))
+}
+
+func TestWriteFileAtomicBasePathFs(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "config.yml"), []byte("a: 1\n"), 0644)
+	os.Symlink("config.yml", filepath.Join(dir, "link.yml"))
+	sc := NewContext()
+	sc.SetFs(afero.NewBasePathFs(afero.NewOsFs(), dir))
+
+	assert.Nil(sc.WriteFileAtomic("/link.yml", []byte("a: 2\n"), 0600))
+	target, err := os.Readlink(filepath.Join(dir, "link.yml"))
+	assert.Nil(err)
+	assert.Equal("config.yml", target)
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "config.yml"))
+	assert.Equal("a: 2\n", string(content))
+}
+
+// chownDeniedFs fails to change the owner of files like for regular users.
+type chownDeniedFs struct {
+	afero.Fs
+}
+
+func (fs chownDeniedFs) Chown(name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EPERM}
+}
+
+func TestWriteFileAtomicForeignOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating files of other users requires root")
+	}
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.yml")
+	os.WriteFile(filename, []byte("a: 1\n"), 0664)
+	os.Chown(filename, 12345, 12345)
+	sc := NewContext()
+	sc.SetFs(chownDeniedFs{afero.NewOsFs()})
+	sc.SetWorkingDir(dir)
+
+	// root is expected to keep the owner
+	assert.ErrorIs(sc.WriteFileAtomic("config.yml", []byte("a: 2\n"), 0600), syscall.EPERM)
+
+	// regular users write the file, but become its owner
+	geteuid = func() int { return 1000 }
+	defer func() { geteuid = os.Geteuid }()
+	assert.Nil(sc.WriteFileAtomic("config.yml", []byte("a: 2\n"), 0600))
+	content, _ := ioutil.ReadFile(filename)
+	assert.Equal("a: 2\n", string(content))
+
+	// no chown needed for files owned already
+	assert.Nil(sc.WriteFileAtomic("config.yml", []byte("a: 3\n"), 0600))
+}
+
+func TestFileMemMapFs(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	sc := NewContext()
+	sc.SetFs(fs)
+	assert.Equal(fs, sc.Fs())
+	fs.MkdirAll("/etc/app", 0755)
+	assert.Nil(sc.SetWorkingDir("/etc/app"))
+
+	assert.Nil(sc.WriteFileAtomicOpts("app.cfg", []byte("useTLS: yes\nhost: github.com\n"), WriteFileOptions{Perm: 0600, Backup: true}))
+	assert.False(sc.FileExists("app.cfg" + BackupSuffix))
+	has, err := sc.FileHasContent("app.cfg", "github.com")
+	assert.Nil(err)
+	assert.True(has)
+	has, err = sc.FileHasContentRegexp("app.cfg", `useTLS: (yes|no)`)
+	assert.Nil(err)
+	assert.True(has)
+
+	assert.Nil(sc.ReplaceInFile("app.cfg", `useTLS: yes`, `useTLS: no`))
+	content, err := afero.ReadFile(fs, "/etc/app/app.cfg")
+	assert.Nil(err)
+	assert.Equal("useTLS: no\nhost: github.com\n", string(content))
+	info, err := fs.Stat("/etc/app/app.cfg")
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	assert.Nil(sc.WriteFileAtomicOpts("app.cfg", []byte("useTLS: yes\n"), WriteFileOptions{Backup: true}))
+	content, _ = afero.ReadFile(fs, "/etc/app/app.cfg"+BackupSuffix)
+	assert.Equal("useTLS: no\nhost: github.com\n", string(content))
+
+	// nothing touched the real filesystem
+	_, err = os.Stat("/etc/app/app.cfg")
+	assert.True(os.IsNotExist(err))
+
+	_, err = sc.TempFile()
+	assert.Equal(ErrNoOsFile, err)
+}
+
+func TestEnsureLine(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/hosts"
+	afero.WriteFile(sc.Fs(), filename, []byte("127.0.0.1 localhost"), 0644)
+
+	changed, err := sc.EnsureLine(filename, "10.0.0.1 db")
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.EnsureLine(filename, "10.0.0.1 db")
+	assert.Nil(err)
+	assert.False(changed)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("127.0.0.1 localhost\n10.0.0.1 db\n", string(content))
+
+	changed, err = sc.EnsureLineRegexp(filename, `\sdb$`, "10.0.0.2 db")
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.EnsureLineRegexp(filename, `\sdb$`, "10.0.0.2 db")
+	assert.Nil(err)
+	assert.False(changed)
+	changed, err = sc.EnsureLineRegexp(filename, `\scache$`, "10.0.0.3 cache")
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ = afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("127.0.0.1 localhost\n10.0.0.2 db\n10.0.0.3 cache\n", string(content))
+
+	_, err = sc.EnsureLineRegexp(filename, `\p`, "invalid")
+	assert.NotNil(err)
+	_, err = sc.EnsureLine("/etc/missing", "line")
+	assert.NotNil(err)
+}
+
+func TestRemoveLines(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/hosts"
+	afero.WriteFile(sc.Fs(), filename, []byte("127.0.0.1 localhost\n10.0.0.1 db\n10.0.0.2 db\n"), 0644)
+
+	changed, err := sc.RemoveLines(filename, `\sdb$`)
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.RemoveLines(filename, `\sdb$`)
+	assert.Nil(err)
+	assert.False(changed)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("127.0.0.1 localhost\n", string(content))
+}
+
+func TestCommentLines(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/ssh/sshd_config"
+	afero.WriteFile(sc.Fs(), filename, []byte("Port 22\n  PermitRootLogin yes\n# PasswordAuthentication yes\n"), 0644)
+
+	changed, err := sc.CommentLines(filename, `^PermitRootLogin`, "")
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.CommentLines(filename, `^PermitRootLogin`, "")
+	assert.Nil(err)
+	assert.False(changed)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("Port 22\n  #PermitRootLogin yes\n# PasswordAuthentication yes\n", string(content))
+
+	changed, err = sc.UncommentLines(filename, `^(PermitRootLogin|PasswordAuthentication)`, "#")
+	assert.Nil(err)
+	assert.True(changed)
+	changed, err = sc.UncommentLines(filename, `^(PermitRootLogin|PasswordAuthentication)`, "#")
+	assert.Nil(err)
+	assert.False(changed)
+	content, _ = afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("Port 22\n  PermitRootLogin yes\nPasswordAuthentication yes\n", string(content))
+
+	changed, err = sc.CommentLines(filename, `^Port`, "//")
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ = afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("//Port 22\n  PermitRootLogin yes\nPasswordAuthentication yes\n", string(content))
+}
@@ -1,11 +1,15 @@
 package script
 
 import (
+	"context"
+	"errors"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -13,17 +17,18 @@ import (
 // FileExists checks if a given filename exists (being a file).
 func (c *Context) FileExists(filename string) bool {
 	filename = c.AbsPath(filename)
-	fi, err := c.fs.Stat(filename)
+	fi, err := c.Fs().Stat(filename)
 	return !os.IsNotExist(err) && !fi.IsDir()
 }
 
 // EnsureDirExists ensures a directory with the given name exists.
 // This function panics if it is unable to find or create a directory as requested.
 // TODO also check if permissions are less than requested and update if possible
-func (c *Context) EnsureDirExists(dirname string, perm os.FileMode) error {
+func (c *Context) EnsureDirExists(dirname string, perm os.FileMode) (err error) {
 	fullPath := c.AbsPath(dirname)
+	defer c.traceOp(TraceEnsureDir, time.Now(), &err, fullPath)
 	if !c.DirExists(fullPath) {
-		err := c.fs.MkdirAll(fullPath, perm)
+		err := c.Fs().MkdirAll(fullPath, perm)
 		if err != nil {
 			return err
 		}
@@ -42,26 +47,54 @@ func (c *Context) EnsurePathForFile(filename string, perm os.FileMode) error {
 // DirExists checks if a given filename exists (being a directory).
 func (c *Context) DirExists(path string) bool {
 	path = c.AbsPath(path)
-	fi, err := c.fs.Stat(path)
+	fi, err := c.Fs().Stat(path)
 	return !os.IsNotExist(err) && fi.IsDir()
 }
 
-// TempFile returns a temporary file and an error if one occurred
+// ErrNoOsFile is returned by TempFile if the filesystem of the Context does
+// not return *os.File.
+var ErrNoOsFile = errors.New("filesystem does not use *os.File")
+
+// TempFile returns a temporary file and an error if one occurred.
+// The file is removed by Cleanup. It requires the filesystem of the Context to
+// use *os.File, like afero.OsFs does, and returns ErrNoOsFile otherwise.
 func (c *Context) TempFile() (*os.File, error) {
 	file, err := c.tempFileInternal()
 	if err != nil {
 		return nil, err
 	}
-	return file.(*os.File), nil
+	osFile, ok := file.(*os.File)
+	if !ok {
+		file.Close()
+		return nil, ErrNoOsFile
+	}
+	return osFile, nil
 }
 
-func (c *Context) tempFileInternal() (afero.File, error) {
-	return afero.TempFile(c.fs, "", "")
+func (c *Context) tempFileInternal() (file afero.File, err error) {
+	start := time.Now()
+	fs := c.Fs()
+	file, err = afero.TempFile(fs, "", "")
+	name := ""
+	if err == nil {
+		name = file.Name()
+		c.cleanup.addTempPath(fs, name)
+	}
+	c.traceOp(TraceTempFile, start, &err, name)
+	return
 }
 
-// TempDir returns a temporary directory and an error if one occurred
-func (c *Context) TempDir() (string, error) {
-	return afero.TempDir(c.fs, "", "")
+// TempDir returns a temporary directory and an error if one occurred.
+// The directory is removed by Cleanup.
+func (c *Context) TempDir() (dir string, err error) {
+	start := time.Now()
+	fs := c.Fs()
+	dir, err = afero.TempDir(fs, "", "")
+	if err == nil {
+		c.cleanup.addTempPath(fs, dir)
+	}
+	c.traceOp(TraceTempDir, start, &err, dir)
+	return
 }
 
 // AbsPath returns the absolute path of the path given. If the input path
@@ -76,7 +109,7 @@ func (c *Context) AbsPath(filename string) string {
 	}
 	isAbsolute := absPath == filename
 	if !isAbsolute {
-		absPath, err := filepath.Abs(path.Join(c.workingDir, filename))
+		absPath, err := filepath.Abs(path.Join(c.WorkingDir(), filename))
 		if err != nil {
 			return filename
 		}
@@ -103,102 +136,210 @@ func (c *Context) WithTrailingPathSep(input string) string {
 	return input + string(os.PathSeparator)
 }
 
-// ResolveSymlinks resolve symlinks in a directory. All symlinked files are
-// replaced with copies of the files they point to. Only one level symlinks
-// are currently supported.
-func (c *Context) ResolveSymlinks(dir string) error {
-	var (
-		err            error
-		linkTargetPath string
-		targetInfo     os.FileInfo
-	)
-	// directory does not exist -> nothing to do
-	dir = c.AbsPath(dir)
-	if !c.DirExists(dir) {
-		return nil
-	}
-	err = afero.Walk(c.fs, dir, func(path string, info os.FileInfo, err error) error {
-		// symlink?
-		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
-			// resolve
-			linkTargetPath, err = filepath.EvalSymlinks(path)
-			if err != nil {
-				panic(err)
-			}
-			targetInfo, err = c.fs.Stat(linkTargetPath)
-			if err != nil {
-				panic(err)
-			}
-			c.fs.Remove(path)
-			// directory?
-			if targetInfo.IsDir() {
-				c.CopyDir(linkTargetPath, path)
-			} else {
-				c.CopyFile(linkTargetPath, path)
-			}
-		}
-		return err
-	})
-	return err
-}
-
 /* Move/Copy Files and Directories */
 
-// MoveFile moves a file. Cross-device moving is supported, so files
-// can be moved from and to tmpfs mounts.
+// MoveFile moves a file. It is renamed if possible. Cross-device moving is
+// supported, so files can be moved from and to tmpfs mounts.
 func (c *Context) MoveFile(from, to string) error {
+	return c.MoveFileOpts(from, to, CopyFileOptions{})
+}
+
+// MoveFileOpts is a variant of MoveFile that takes options for copying the
+// file, which are used only if it can not be renamed because source and
+// destination are on different devices. The copy is made next to the
+// destination and renamed, so the destination is never partially written.
+// The mode of the file is always kept. The source is kept if copying fails or
+// is canceled.
+func (c *Context) MoveFileOpts(from, to string, options CopyFileOptions) (err error) {
 	from = c.AbsPath(from)
 	to = c.AbsPath(to)
+	defer c.traceOp(TraceMoveFile, time.Now(), &err, from, to)
 
-	// work around "invalid cross-device link" for os.Rename
-	err := CopyFile(c.fs, from, to, true)
+	if options.Ctx != nil && options.Ctx.Err() != nil {
+		return options.Ctx.Err()
+	}
+	fs := c.Fs()
+	err = fs.Rename(from, to)
+	if !isCrossDevice(err) {
+		return err
+	}
+
+	dir, base := filepath.Split(to)
+	tmp, err := afero.TempFile(fs, dir, "."+base+".tmp")
 	if err != nil {
 		return err
 	}
-	err = c.fs.Remove(from)
+	tmp.Close()
+	// the temporary file is private, give the copy the mode of the source
+	options.Preserve.Mode = true
+	err = copyFileOpts(fs, from, tmp.Name(), options)
+	if err == nil {
+		err = fs.Rename(tmp.Name(), to)
+	}
 	if err != nil {
+		fs.Remove(tmp.Name())
 		return err
 	}
-	return nil
+	return fs.Remove(from)
 }
 
-// MoveDir moves a directory. Cross-device moving is supported, so directories
-// can be moved from and to tmpfs mounts.
+// MoveDir moves a directory. It is renamed if possible. Cross-device moving is
+// supported, so directories can be moved from and to tmpfs mounts.
 func (c *Context) MoveDir(from, to string) error {
+	return c.MoveDirOpts(from, to, CopyTreeOptions{})
+}
+
+// MoveDirOpts is a variant of MoveDir that takes options for copying the
+// directory, see CopyTree for details. The directory is renamed if possible,
+// unless Merge or Ignore are set. Otherwise it is copied and the source is
+// removed afterwards. If copying fails or is canceled, the source is kept and
+// the files and directories created in the destination are removed again.
+// Files of an existing destination that were updated or removed because of
+// Merge or Delete are not restored.
+func (c *Context) MoveDirOpts(from, to string, options CopyTreeOptions) (err error) {
 	from = c.AbsPath(from)
 	to = c.AbsPath(to)
+	defer c.traceOp(TraceMoveDir, time.Now(), &err, from, to)
+
+	if options.Ctx != nil && options.Ctx.Err() != nil {
+		return options.Ctx.Err()
+	}
+	fs := c.Fs()
+	if !options.Merge && options.Ignore == nil {
+		if _, err := fs.Stat(to); err == nil {
+			return &AlreadyExistsError{to}
+		}
+		info, err := fs.Stat(from)
+		if err != nil {
+			return err
+		}
+		// like CopyTree does
+		err = fs.MkdirAll(filepath.Dir(to), info.Mode())
+		if err != nil {
+			return err
+		}
+		if renamesContent(fs, filepath.Dir(to)) {
+			err = fs.Rename(from, to)
+			if !isCrossDevice(err) {
+				return err
+			}
+		}
+	}
 
 	// work around "invalid cross-device link" for os.Rename
-	options := &CopyTreeOptions{
-		Ignore:       nil,
-		CopyFunction: Copy,
+	if options.Report == nil {
+		options.Report = &CopyReport{}
 	}
-	err := CopyTree(c.fs, from, to, options)
+	err = CopyTree(fs, from, to, &options)
 	if err != nil {
+		created := options.Report.Created
+		for i := len(created) - 1; i >= 0; i-- {
+			fs.RemoveAll(created[i])
+		}
 		return err
 	}
-	err = c.fs.RemoveAll(from)
+	return fs.RemoveAll(from)
+}
+
+// renamesContent checks if renaming a directory in dir moves its content,
+// too. afero.MemMapFs and filesystems wrapping it only rename the directory
+// itself, so a test directory is renamed unless fs is an afero.OsFs.
+func renamesContent(fs afero.Fs, dir string) bool {
+	if _, ok := fs.(*afero.OsFs); ok {
+		return true
+	}
+	tmp, err := afero.TempDir(fs, dir, ".rename")
 	if err != nil {
-		return err
+		return false
 	}
-	return nil
+	defer fs.RemoveAll(tmp)
+	from := filepath.Join(tmp, "from")
+	to := filepath.Join(tmp, "to")
+	err = fs.Mkdir(from, 0700)
+	if err == nil {
+		err = afero.WriteFile(fs, filepath.Join(from, "file"), nil, 0600)
+	}
+	if err == nil {
+		err = fs.Rename(from, to)
+	}
+	if err != nil {
+		return false
+	}
+	_, err = fs.Stat(filepath.Join(to, "file"))
+	return err == nil
+}
+
+// isCrossDevice checks if err is the result of renaming a file to another
+// device.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// CopyFileOptions defines details of copying a file.
+type CopyFileOptions struct {
+	// Symlinks copies a symlink as symlink instead of the file it points to.
+	Symlinks bool
+	// Preserve defines which metadata is kept.
+	Preserve PreserveOptions
+	// Progress is called repeatedly while copying.
+	Progress ProgressFunc
+	// Ctx stops copying when it is canceled, no partial copy is kept.
+	Ctx context.Context
 }
 
 // CopyFile copies a file. Cross-device copying is supported, so files
-// can be copied from and to tmpfs mounts.
+// can be copied from and to tmpfs mounts. Symlinks are followed.
 func (c *Context) CopyFile(from, to string) error {
-	return CopyFile(c.fs, from, to, true) // don't follow symlinks
+	return c.CopyFileOpts(from, to, CopyFileOptions{})
+}
+
+// CopyFileOpts is a variant of CopyFile that takes options.
+func (c *Context) CopyFileOpts(from, to string, options CopyFileOptions) (err error) {
+	from = c.AbsPath(from)
+	to = c.AbsPath(to)
+	defer c.traceOp(TraceCopyFile, time.Now(), &err, from, to)
+	return copyFileOpts(c.Fs(), from, to, options)
+}
+
+func copyFileOpts(fs afero.Fs, from, to string, options CopyFileOptions) error {
+	var total int64
+	if options.Progress != nil {
+		info, err := fs.Stat(from)
+		if err != nil {
+			return err
+		}
+		total = info.Size()
+	}
+	err := copyFile(fs, from, to, !options.Symlinks, newCopyState(options.Ctx, options.Progress, total))
+	if err != nil {
+		return err
+	}
+	if options.Symlinks && isSymlink(fs, from) {
+		return nil
+	}
+	info, err := fs.Stat(from)
+	if err != nil {
+		return err
+	}
+	return options.Preserve.preserve(fs, info, to)
 }
 
 // CopyDir copies a directory. Cross-device copying is supported, so directories
 // can be copied from and to tmpfs mounts.
 func (c *Context) CopyDir(src, dst string) error {
-	options := &CopyTreeOptions{
+	return c.CopyDirOpts(src, dst, CopyTreeOptions{
 		Ignore:       nil,
 		CopyFunction: Copy,
-	}
-	err := CopyTree(c.fs, src, dst, options)
-	return err
+	})
+}
+
+// CopyDirOpts is a variant of CopyDir that takes options, e.g. to merge into
+// an existing directory. See CopyTree for details.
+func (c *Context) CopyDirOpts(src, dst string, options CopyTreeOptions) (err error) {
+	src = c.AbsPath(src)
+	dst = c.AbsPath(dst)
+	defer c.traceOp(TraceCopyDir, time.Now(), &err, src, dst)
+	return CopyTree(c.Fs(), src, dst, &options)
 }
 
 // MustExpandHome replaces a tilde (~) in a path with the current user's home dir.
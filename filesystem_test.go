@@ -1,9 +1,13 @@
 package script
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -57,6 +61,8 @@ func TestDirExists(t *testing.T) {
 
 func TestAbsPath(t *testing.T) {
 	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/wd")
 	sc.SetWorkingDir("/wd")
 	assert.Equal(t, "/wd/file", sc.AbsPath("file"))
 	assert.Equal(t, "/wd/dir", sc.AbsPath("dir"))
@@ -68,6 +74,8 @@ func TestAbsPath(t *testing.T) {
 
 func TestAbsPathSep(t *testing.T) {
 	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, "/wd")
 	sc.SetWorkingDir("/wd")
 	assert.Equal(t, "/wd/dir/", sc.AbsPathSep("dir"))
 	assert.Equal(t, "/wd/dir/", sc.AbsPathSep("dir/"))
@@ -95,6 +103,7 @@ func TestResolveSymlinks(t *testing.T) {
 	sc := NewContext()
 	fs := afero.NewMemMapFs()
 	sc.fs = fs
+	makeDirectory(sc, "/test")
 	sc.SetWorkingDir("/test")
 
 	err := sc.ResolveSymlinks("dir-non-existing")
@@ -104,8 +113,6 @@ func TestResolveSymlinks(t *testing.T) {
 	afero.WriteFile(fs, "/test/dir/file.txt", []byte("This is my content"), os.FileMode(0644))
 	err = sc.ResolveSymlinks("dir")
 	assert.Nil(t, err)
-
-	// TODO test actual symlinks (can afero mock os.Symlink to its MemFS?)
 }
 
 func TestEnsureDirExists(t *testing.T) {
@@ -114,6 +121,7 @@ func TestEnsureDirExists(t *testing.T) {
 	fullPath := filepath.Join(path, dir)
 	sc := NewContext()
 	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, path)
 	sc.SetWorkingDir(path)
 	err := sc.EnsureDirExists(dir, myFileFileMode)
 	assert.Nil(t, err)
@@ -127,6 +135,7 @@ func TestEnsurePathForFile(t *testing.T) {
 	file := "xyz.zip"
 	sc := NewContext()
 	sc.fs = afero.NewMemMapFs()
+	makeDirectory(sc, path)
 	sc.SetWorkingDir(path)
 	err := sc.EnsurePathForFile(file, myFileFileMode)
 	assert.Nil(t, err)
@@ -282,3 +291,357 @@ func fileExists(c *Context, filename string) bool {
 	r, err := afero.Exists(c.fs, filename)
 	return r && err == nil
 }
+
+func TestCopyDirOpts(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	past := time.Now().Add(-time.Hour)
+	files := map[string]string{
+		"/src/same":        "same",
+		"/src/changed":     "new content",
+		"/src/newer":       "newer",
+		"/src/new":         "new",
+		"/src/sub/file":    "file",
+		"/dst/same":        "same",
+		"/dst/changed":     "old content",
+		"/dst/newer":       "older",
+		"/dst/extraneous":  "extraneous",
+		"/dst/old/file":    "old",
+		"/dst/sub/ignored": "ignored",
+	}
+	for name, content := range files {
+		sc.Fs().MkdirAll(filepath.Dir(name), 0755)
+		afero.WriteFile(sc.Fs(), name, []byte(content), 0644)
+		sc.Fs().Chtimes(name, past, past)
+	}
+	sc.Fs().Chtimes("/src/newer", time.Now(), time.Now())
+
+	// existing destination
+	err := sc.CopyDir("/src", "/dst")
+	assert.IsType(&AlreadyExistsError{}, err)
+
+	tests := []struct {
+		policy OverwritePolicy
+		report CopyReport
+	}{
+		{OverwriteNever, CopyReport{
+			Created: []string{"/dst/new", "/dst/sub/file"},
+			Skipped: []string{"/dst/changed", "/dst/newer", "/dst/same"},
+		}},
+		{OverwriteIfNewer, CopyReport{
+			Updated: []string{"/dst/newer"},
+			Skipped: []string{"/dst/changed", "/dst/new", "/dst/same", "/dst/sub/file"},
+		}},
+		{OverwriteIfDifferent, CopyReport{
+			Updated: []string{"/dst/changed"},
+			Skipped: []string{"/dst/new", "/dst/newer", "/dst/same", "/dst/sub/file"},
+		}},
+		{OverwriteAlways, CopyReport{
+			Updated: []string{"/dst/changed", "/dst/new", "/dst/newer", "/dst/same", "/dst/sub/file"},
+		}},
+	}
+	for _, test := range tests {
+		report := &CopyReport{}
+		err = sc.CopyDirOpts("/src", "/dst", CopyTreeOptions{
+			Merge:     true,
+			Overwrite: test.policy,
+			Report:    report,
+		})
+		assert.Nil(err)
+		assert.Equal(test.report, *report, "policy %d", test.policy)
+	}
+	content, _ := afero.ReadFile(sc.Fs(), "/dst/changed")
+	assert.Equal("new content", string(content))
+
+	// delete extraneous files, keeping ignored ones
+	report := &CopyReport{}
+	err = sc.CopyDirOpts("/src", "/dst", CopyTreeOptions{
+		Merge:     true,
+		Overwrite: OverwriteIfDifferent,
+		Delete:    true,
+		Report:    report,
+		Ignore: func(src string, entries []os.FileInfo) []string {
+			return []string{"ignored"}
+		},
+	})
+	assert.Nil(err)
+	assert.Equal([]string{"/dst/extraneous", "/dst/old"}, report.Removed)
+	assert.True(fileExists(sc, "/dst/sub/ignored"))
+	assert.False(sc.DirExists("/dst/old"))
+
+	// new destination
+	report = &CopyReport{}
+	err = sc.CopyDirOpts("/src/sub", "/dst2", CopyTreeOptions{Report: report})
+	assert.Nil(err)
+	assert.Equal([]string{"/dst2", "/dst2/file"}, report.Created)
+}
+
+func TestCopyDirOptsSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetWorkingDir(dir)
+	os.MkdirAll(filepath.Join(dir, "src", "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "sub", "file"), []byte("content"), 0644)
+	os.Symlink("sub/file", filepath.Join(dir, "src", "link"))
+	os.Symlink("sub", filepath.Join(dir, "src", "dirlink"))
+	os.Symlink("missing", filepath.Join(dir, "src", "dangling"))
+
+	// as symlinks
+	assert.Nil(sc.CopyDirOpts("src", "links", CopyTreeOptions{Symlinks: true}))
+	target, err := os.Readlink(filepath.Join(dir, "links", "link"))
+	assert.Nil(err)
+	assert.Equal("sub/file", target)
+	target, _ = os.Readlink(filepath.Join(dir, "links", "dirlink"))
+	assert.Equal("sub", target)
+	target, _ = os.Readlink(filepath.Join(dir, "links", "dangling"))
+	assert.Equal("missing", target)
+
+	// followed
+	err = sc.CopyDir("src", "followed")
+	assert.True(os.IsNotExist(err), "%v", err)
+	report := &CopyReport{}
+	assert.Nil(sc.CopyDirOpts("src", "followed", CopyTreeOptions{Merge: true, IgnoreDanglingSymlinks: true, Report: report}))
+	assert.Equal([]string{filepath.Join(dir, "followed", "dangling")}, report.Skipped)
+	info, err := os.Lstat(filepath.Join(dir, "followed", "link"))
+	assert.Nil(err)
+	assert.True(info.Mode().IsRegular())
+	info, err = os.Lstat(filepath.Join(dir, "followed", "dirlink"))
+	assert.Nil(err)
+	assert.True(info.IsDir())
+	assert.True(sc.FileExists("followed/dirlink/file"))
+
+	// single files
+	assert.Nil(sc.CopyFileOpts("src/link", "link", CopyFileOptions{Symlinks: true}))
+	target, _ = os.Readlink(filepath.Join(dir, "link"))
+	assert.Equal("sub/file", target)
+	assert.Nil(sc.CopyFile("src/link", "file"))
+	info, _ = os.Lstat(filepath.Join(dir, "file"))
+	assert.True(info.Mode().IsRegular())
+}
+
+func TestCopyDirOptsPreserve(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetWorkingDir(dir)
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.MkdirAll(filepath.Join(dir, "src", "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "sub", "file"), []byte("content"), 0644)
+	if os.Getuid() == 0 {
+		os.Chown(filepath.Join(dir, "src", "sub", "file"), 1234, 1234)
+	}
+	os.Chmod(filepath.Join(dir, "src", "sub", "file"), 0750|os.ModeSetgid)
+	os.Chmod(filepath.Join(dir, "src", "sub"), 0700|os.ModeSticky)
+	os.Chtimes(filepath.Join(dir, "src", "sub", "file"), past, past)
+	os.Chtimes(filepath.Join(dir, "src", "sub"), past, past)
+
+	options := CopyTreeOptions{Preserve: PreserveAll}
+	if os.Getuid() != 0 {
+		options.Preserve.Owner = false
+	}
+	assert.Nil(sc.CopyDirOpts("src", "dst", options))
+
+	info, err := os.Stat(filepath.Join(dir, "dst", "sub", "file"))
+	assert.Nil(err)
+	assert.Equal(0750|os.ModeSetgid, info.Mode())
+	assert.True(past.Equal(info.ModTime()))
+	if os.Getuid() == 0 {
+		assert.Equal(uint32(1234), info.Sys().(*syscall.Stat_t).Uid)
+	}
+	info, err = os.Stat(filepath.Join(dir, "dst", "sub"))
+	assert.Nil(err)
+	assert.Equal(0700|os.ModeSticky|os.ModeDir, info.Mode())
+	assert.True(past.Equal(info.ModTime()))
+
+	assert.Nil(sc.CopyFileOpts("src/sub/file", "file", CopyFileOptions{Preserve: PreserveOptions{Times: true}}))
+	info, _ = os.Stat(filepath.Join(dir, "file"))
+	assert.True(past.Equal(info.ModTime()))
+}
+
+func TestCopyDirOptsProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	sc.Fs().MkdirAll("/src/sub", 0755)
+	afero.WriteFile(sc.Fs(), "/src/a", make([]byte, 100*1024), 0644)
+	afero.WriteFile(sc.Fs(), "/src/sub/b", []byte("b"), 0644)
+
+	for _, copyFunction := range []func(afero.Fs, string, string, bool) (string, error){nil, Copy} {
+		var progress []CopyProgress
+		err := sc.CopyDirOpts("/src", "/dst", CopyTreeOptions{
+			CopyFunction: copyFunction,
+			Progress: func(p CopyProgress) {
+				progress = append(progress, p)
+			},
+		})
+		assert.Nil(err)
+		assert.GreaterOrEqual(len(progress), 2)
+		last := progress[len(progress)-1]
+		assert.Equal(CopyProgress{File: "/src/sub/b", BytesDone: 100*1024 + 1, BytesTotal: 100*1024 + 1}, last)
+		sc.Fs().RemoveAll("/dst")
+	}
+}
+
+func TestCopyCancel(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	sc.Fs().MkdirAll("/src/sub", 0755)
+	afero.WriteFile(sc.Fs(), "/src/a", make([]byte, 100*1024), 0644)
+	afero.WriteFile(sc.Fs(), "/src/sub/b", []byte("b"), 0644)
+
+	// canceled while copying a file
+	ctx, cancel := context.WithCancel(context.Background())
+	err := sc.CopyFileOpts("/src/a", "/a", CopyFileOptions{
+		Ctx: ctx,
+		Progress: func(p CopyProgress) {
+			assert.Equal(int64(100*1024), p.BytesTotal)
+			cancel()
+		},
+	})
+	assert.Equal(context.Canceled, err)
+	assert.False(sc.FileExists("/a"))
+
+	// canceled before
+	err = sc.MoveDirOpts("/src", "/dst", CopyTreeOptions{Ctx: ctx})
+	assert.Equal(context.Canceled, err)
+	assert.True(sc.FileExists("/src/a"))
+	err = sc.MoveFileOpts("/src/a", "/a", CopyFileOptions{Ctx: ctx})
+	assert.Equal(context.Canceled, err)
+	assert.True(sc.FileExists("/src/a"))
+
+	assert.Nil(sc.MoveDirOpts("/src", "/dst", CopyTreeOptions{Ctx: context.Background()}))
+	assert.True(sc.FileExists("/dst/sub/b"))
+	assert.False(sc.DirExists("/src"))
+}
+
+// crossDeviceFs simulates moving from another device for paths in /src and
+// fails to open files named "unreadable".
+type crossDeviceFs struct {
+	afero.Fs
+}
+
+func (fs crossDeviceFs) Rename(oldname, newname string) error {
+	if strings.HasPrefix(oldname, "/src") {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func (fs crossDeviceFs) Open(name string) (afero.File, error) {
+	if filepath.Base(name) == "unreadable" {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.Open(name)
+}
+
+func TestMoveCrossDevice(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(crossDeviceFs{afero.NewMemMapFs()})
+	sc.Fs().MkdirAll("/src/dir/sub", 0755)
+	sc.Fs().MkdirAll("/dst", 0755)
+	afero.WriteFile(sc.Fs(), "/src/file", []byte("file"), 0755)
+	afero.WriteFile(sc.Fs(), "/src/unreadable", []byte("unreadable"), 0644)
+	afero.WriteFile(sc.Fs(), "/src/dir/a", []byte("a"), 0644)
+	afero.WriteFile(sc.Fs(), "/src/dir/sub/unreadable", []byte("unreadable"), 0644)
+	afero.WriteFile(sc.Fs(), "/dst/existing", []byte("existing"), 0644)
+
+	assert.Nil(sc.MoveFile("/src/file", "/dst/file"))
+	assert.False(sc.FileExists("/src/file"))
+	content, _ := afero.ReadFile(sc.Fs(), "/dst/file")
+	assert.Equal("file", string(content))
+	info, _ := sc.Fs().Stat("/dst/file")
+	assert.Equal(os.FileMode(0755), info.Mode().Perm())
+
+	// the destination is left untouched
+	assert.NotNil(sc.MoveFile("/src/unreadable", "/dst/existing"))
+	assert.True(sc.FileExists("/src/unreadable"))
+	content, _ = afero.ReadFile(sc.Fs(), "/dst/existing")
+	assert.Equal("existing", string(content))
+
+	// the partial copy is removed
+	assert.NotNil(sc.MoveDir("/src/dir", "/dst/dir"))
+	assert.True(sc.FileExists("/src/dir/a"))
+	assert.False(sc.DirExists("/dst/dir"))
+
+	entries, _ := afero.ReadDir(sc.Fs(), "/dst")
+	assert.Len(entries, 2)
+
+	sc.Fs().Remove("/src/dir/sub/unreadable")
+	assert.Nil(sc.MoveDir("/src/dir", "/dst/dir"))
+	assert.False(sc.DirExists("/src/dir"))
+	assert.True(sc.FileExists("/dst/dir/a"))
+
+	// renaming in the wrapped afero.MemMapFs would leave the content behind
+	assert.Nil(sc.MoveDir("/dst/dir", "/moved"))
+	assert.False(sc.DirExists("/dst/dir"))
+	assert.True(sc.FileExists("/moved/a"))
+	assert.True(sc.DirExists("/moved/sub"))
+}
+
+func TestMoveRename(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetWorkingDir(dir)
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "a"), []byte("a"), 0644)
+	os.Link(filepath.Join(dir, "src", "a"), filepath.Join(dir, "src", "b"))
+	before, _ := os.Stat(filepath.Join(dir, "src", "a"))
+
+	assert.Nil(sc.MoveDir("src", "dst"))
+	a, err := os.Stat(filepath.Join(dir, "dst", "a"))
+	assert.Nil(err)
+	b, err := os.Stat(filepath.Join(dir, "dst", "b"))
+	assert.Nil(err)
+	assert.True(os.SameFile(before, a))
+	assert.True(os.SameFile(a, b))
+
+	assert.Nil(sc.MoveFile("dst/a", "a"))
+	a, err = os.Stat(filepath.Join(dir, "a"))
+	assert.Nil(err)
+	assert.True(os.SameFile(before, a))
+
+	os.Mkdir(filepath.Join(dir, "other"), 0755)
+	assert.IsType(&AlreadyExistsError{}, sc.MoveDir("dst", "other"))
+
+	// missing parents are created
+	assert.Nil(sc.MoveDir("dst", "new/parent/dst"))
+	b, err = os.Stat(filepath.Join(dir, "new", "parent", "dst", "b"))
+	assert.Nil(err)
+	assert.True(os.SameFile(before, b))
+}
+
+func TestMoveDirWrappedFs(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	for _, fs := range []afero.Fs{
+		afero.NewBasePathFs(afero.NewOsFs(), dir),
+		afero.NewBasePathFs(afero.NewMemMapFs(), "/base"),
+	} {
+		sc := NewContext()
+		sc.SetFs(fs)
+		fs.MkdirAll("/src/sub", 0755)
+		afero.WriteFile(fs, "/src/sub/file", []byte("file"), 0644)
+
+		assert.Nil(sc.MoveDir("/src", "/new/dst"))
+		assert.False(sc.DirExists("/src"))
+		content, err := afero.ReadFile(fs, "/new/dst/sub/file")
+		assert.Nil(err)
+		assert.Equal("file", string(content))
+		// no test directories are left
+		entries, _ := afero.ReadDir(fs, "/new")
+		assert.Len(entries, 1)
+	}
+}
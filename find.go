@@ -0,0 +1,387 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// FindType restricts Find to a type of directory entries.
+type FindType int
+
+const (
+	// FindAny finds entries of any type.
+	FindAny FindType = iota
+	// FindFiles finds regular files only.
+	FindFiles
+	// FindDirs finds directories only.
+	FindDirs
+	// FindSymlinks finds symbolic links only.
+	FindSymlinks
+)
+
+// FindOptions defines which entries Find returns. Zero values do not filter.
+type FindOptions struct {
+	// Name is a pattern as used by filepath.Match the name of an entry must match.
+	Name string
+	// Regexp is a regular expression the path of an entry relative to the
+	// root of the search must match, with / as separator.
+	Regexp string
+	Type   FindType
+	// MinSize and MaxSize are given in bytes. If one of them is set, only
+	// regular files are found.
+	MinSize int64
+	MaxSize int64
+	// NewerThan and OlderThan are compared to the modification time.
+	NewerThan time.Time
+	OlderThan time.Time
+	// MinDepth and MaxDepth limit the depth of entries found, the entries
+	// of the root directory have depth 1.
+	MinDepth int
+	MaxDepth int
+	// Exclude are patterns in the syntax of .gitignore files, relative to the
+	// root of the search. Excluded directories are not searched.
+	Exclude []string
+	// ExcludeFiles are names of files like ".gitignore". If a directory
+	// contains one of them, its patterns are applied to the directory.
+	ExcludeFiles []string
+}
+
+// Glob returns the names of all files and directories matching the pattern,
+// sorted. The pattern syntax is the one of filepath.Match, with the addition
+// of "**" as path element matching any number of directories. Relative
+// patterns are resolved against the working dir and return relative names.
+func (c *Context) Glob(pattern string) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	for _, segment := range segments {
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+	root := c.WorkingDir()
+	if filepath.IsAbs(pattern) {
+		root = "/"
+		segments = segments[1:]
+	}
+
+	found := make(map[string]bool)
+	err := glob(c.Fs(), root, segments, found)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(found))
+	for path := range found {
+		result = append(result, c.relPath(pattern, path))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func glob(fs afero.Fs, dir string, segments []string, found map[string]bool) error {
+	if len(segments) == 0 {
+		found[dir] = true
+		return nil
+	}
+	segment, rest := segments[0], segments[1:]
+
+	// a trailing "**" matches everything below dir
+	if segment == "**" && len(rest) == 0 {
+		return afero.Walk(fs, dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != dir {
+				found[path] = true
+			}
+			return nil
+		})
+	}
+	if segment == "**" {
+		err := glob(fs, dir, rest, found)
+		if err != nil {
+			return err
+		}
+		entries, err := afero.ReadDir(fs, dir)
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				err = glob(fs, filepath.Join(dir, entry.Name()), segments, found)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if !hasMeta(segment) {
+		path := filepath.Join(dir, segment)
+		if _, err := fs.Stat(path); err != nil {
+			return nil
+		}
+		return glob(fs, path, rest, found)
+	}
+
+	entries, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		// not a directory or not readable, no match
+		return nil
+	}
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(segment, entry.Name()); !ok {
+			continue
+		}
+		err = glob(fs, filepath.Join(dir, entry.Name()), rest, found)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find returns the files, directories and symlinks below root matching the
+// options, sorted. Symlinks to directories are not followed. If root is
+// relative, the names returned are relative to the working dir.
+func (c *Context) Find(root string, options FindOptions) ([]string, error) {
+	var (
+		re  *regexp.Regexp
+		err error
+	)
+	if options.Regexp != "" {
+		re, err = regexp.Compile(options.Regexp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Name != "" {
+		if _, err = filepath.Match(options.Name, ""); err != nil {
+			return nil, err
+		}
+	}
+	var rules []ignoreRule
+	for _, line := range options.Exclude {
+		if rule, ok := parseIgnoreRule("", line); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	f := &finder{
+		fs:      c.Fs(),
+		root:    c.AbsPath(root),
+		options: options,
+		re:      re,
+	}
+	info, err := f.fs.Stat(f.root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &NotADirectoryError{f.root}
+	}
+	err = f.walk("", 0, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(f.found))
+	for i, path := range f.found {
+		result[i] = c.relPath(root, path)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+type finder struct {
+	fs      afero.Fs
+	root    string
+	options FindOptions
+	re      *regexp.Regexp
+	found   []string
+}
+
+// walk searches the directory rel, given relative to the root.
+func (f *finder) walk(rel string, depth int, rules []ignoreRule) error {
+	dir := filepath.Join(f.root, rel)
+	for _, name := range f.options.ExcludeFiles {
+		content, err := afero.ReadFile(f.fs, filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		// copy, so rules of sibling directories do not share the array
+		rules = rules[:len(rules):len(rules)]
+		for _, line := range strings.Split(string(content), "\n") {
+			if rule, ok := parseIgnoreRule(rel, line); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	entries, err := afero.ReadDir(f.fs, dir)
+	if err != nil {
+		return err
+	}
+	depth++
+	for _, entry := range entries {
+		entryRel := filepath.ToSlash(filepath.Join(rel, entry.Name()))
+		if isIgnored(rules, entryRel, entry.IsDir()) {
+			continue
+		}
+		if depth >= f.options.MinDepth && f.matches(entryRel, entry) {
+			f.found = append(f.found, filepath.Join(f.root, entryRel))
+		}
+		if entry.IsDir() && (f.options.MaxDepth == 0 || depth < f.options.MaxDepth) {
+			err = f.walk(entryRel, depth, rules)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *finder) matches(rel string, info os.FileInfo) bool {
+	o := f.options
+	if o.Name != "" {
+		if ok, _ := filepath.Match(o.Name, info.Name()); !ok {
+			return false
+		}
+	}
+	if f.re != nil && !f.re.MatchString(rel) {
+		return false
+	}
+	switch o.Type {
+	case FindFiles:
+		if !info.Mode().IsRegular() {
+			return false
+		}
+	case FindDirs:
+		if !info.IsDir() {
+			return false
+		}
+	case FindSymlinks:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return false
+		}
+	}
+	if o.MinSize > 0 || o.MaxSize > 0 {
+		if !info.Mode().IsRegular() || info.Size() < o.MinSize || (o.MaxSize > 0 && info.Size() > o.MaxSize) {
+			return false
+		}
+	}
+	if !o.NewerThan.IsZero() && !info.ModTime().After(o.NewerThan) {
+		return false
+	}
+	if !o.OlderThan.IsZero() && !info.ModTime().Before(o.OlderThan) {
+		return false
+	}
+	return true
+}
+
+// ignoreRule is a single pattern of a .gitignore file.
+type ignoreRule struct {
+	// base is the directory of the .gitignore file relative to the root.
+	base     string
+	segments []string
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: filepath.ToSlash(base)}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// patterns containing a slash are relative to the .gitignore file,
+	// others match names at any depth
+	rule.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if !r.anchored {
+		ok, _ := filepath.Match(r.segments[0], filepath.Base(rel))
+		return ok
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+// isIgnored checks a path relative to the root against the rules, the last
+// matching rule wins.
+func isIgnored(rules []ignoreRule, rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.match(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path elements against pattern elements, "**" matches
+// any number of path elements.
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	ok, _ := filepath.Match(pattern[0], path[0])
+	return ok && matchSegments(pattern[1:], path[1:])
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// relPath returns path relative to the working dir if input is relative,
+// otherwise path is returned unchanged.
+func (c *Context) relPath(input, path string) string {
+	if filepath.IsAbs(input) {
+		return path
+	}
+	rel, err := filepath.Rel(c.WorkingDir(), path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
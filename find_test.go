@@ -0,0 +1,117 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func findContext() *Context {
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	files := map[string]string{
+		"/project/main.go":              "package main",
+		"/project/README.md":            "# readme",
+		"/project/.gitignore":           "*.log\n# comment\n/build/\n",
+		"/project/debug.log":            "log",
+		"/project/build/app":            "binary",
+		"/project/pkg/a/a.go":           "package a",
+		"/project/pkg/a/a_test.go":      "package a",
+		"/project/pkg/a/.gitignore":     "generated.go\n",
+		"/project/pkg/a/generated.go":   "package a",
+		"/project/pkg/b/b.go":           "package b // larger file",
+		"/project/pkg/b/testdata/x.txt": "x",
+	}
+	for name, content := range files {
+		sc.Fs().MkdirAll(filepath.Dir(name), 0755)
+		afero.WriteFile(sc.Fs(), name, []byte(content), 0644)
+	}
+	sc.SetWorkingDir("/project")
+	return sc
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.go", []string{"main.go"}},
+		{"pkg/*/*.go", []string{"pkg/a/a.go", "pkg/a/a_test.go", "pkg/a/generated.go", "pkg/b/b.go"}},
+		{"**/*_test.go", []string{"pkg/a/a_test.go"}},
+		{"pkg/**", []string{"pkg/a", "pkg/a/.gitignore", "pkg/a/a.go", "pkg/a/a_test.go", "pkg/a/generated.go", "pkg/b", "pkg/b/b.go", "pkg/b/testdata", "pkg/b/testdata/x.txt"}},
+		{"pkg/**/x.txt", []string{"pkg/b/testdata/x.txt"}},
+		{"/project/pkg/?/b.go", []string{"/project/pkg/b/b.go"}},
+		{"README.md", []string{"README.md"}},
+		{"missing/*.go", []string{}},
+	}
+
+	sc := findContext()
+	for _, test := range tests {
+		result, err := sc.Glob(test.pattern)
+		assert.Nil(t, err, test.pattern)
+		assert.Equal(t, test.expected, result, test.pattern)
+	}
+
+	_, err := sc.Glob("[")
+	assert.NotNil(t, err)
+}
+
+func TestFind(t *testing.T) {
+	sc := findContext()
+	past := time.Now().Add(-time.Hour)
+	sc.Fs().Chtimes("/project/README.md", past, past)
+
+	tests := []struct {
+		root     string
+		options  FindOptions
+		expected []string
+	}{
+		{".", FindOptions{Name: "*.go"}, []string{"main.go", "pkg/a/a.go", "pkg/a/a_test.go", "pkg/a/generated.go", "pkg/b/b.go"}},
+		{"pkg", FindOptions{Regexp: `^a/.*_test\.go$`}, []string{"pkg/a/a_test.go"}},
+		{"pkg", FindOptions{Type: FindDirs}, []string{"pkg/a", "pkg/b", "pkg/b/testdata"}},
+		{"pkg", FindOptions{Type: FindFiles, MaxDepth: 2, Name: "*.go"}, []string{"pkg/a/a.go", "pkg/a/a_test.go", "pkg/a/generated.go", "pkg/b/b.go"}},
+		{"pkg", FindOptions{MinDepth: 3}, []string{"pkg/b/testdata/x.txt"}},
+		{"pkg", FindOptions{MinSize: 20}, []string{"pkg/b/b.go"}},
+		{"pkg", FindOptions{MaxSize: 1}, []string{"pkg/b/testdata/x.txt"}},
+		{".", FindOptions{OlderThan: time.Now().Add(-time.Minute)}, []string{"README.md"}},
+		{".", FindOptions{NewerThan: time.Now().Add(-time.Minute), Name: "*.md"}, []string{}},
+		{".", FindOptions{Name: "*.go", ExcludeFiles: []string{".gitignore"}}, []string{"main.go", "pkg/a/a.go", "pkg/a/a_test.go", "pkg/b/b.go"}},
+		{".", FindOptions{Type: FindFiles, ExcludeFiles: []string{".gitignore"}, Exclude: []string{".gitignore", "pkg/**/testdata/", "*.go", "!a.go"}}, []string{"README.md", "pkg/a/a.go"}},
+		{"/project/build", FindOptions{}, []string{"/project/build/app"}},
+	}
+
+	for _, test := range tests {
+		result, err := sc.Find(test.root, test.options)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, result, "%+v", test.options)
+	}
+
+	_, err := sc.Find("main.go", FindOptions{})
+	assert.IsType(t, &NotADirectoryError{}, err)
+	_, err = sc.Find("missing", FindOptions{})
+	assert.True(t, os.IsNotExist(err))
+	_, err = sc.Find(".", FindOptions{Regexp: `\p`})
+	assert.NotNil(t, err)
+}
+
+func TestFindSymlinks(t *testing.T) {
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetWorkingDir(dir)
+	os.Mkdir(filepath.Join(dir, "real"), 0755)
+	os.WriteFile(filepath.Join(dir, "real", "file"), []byte("x"), 0644)
+	os.Symlink("real", filepath.Join(dir, "link"))
+
+	result, err := sc.Find(".", FindOptions{Type: FindSymlinks})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"link"}, result)
+
+	// symlinked directories are not followed
+	result, err = sc.Find(".", FindOptions{Name: "file"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"real/file"}, result)
+}
@@ -1,6 +1,6 @@
 module github.com/jojomi/go-script/v2
 
-go 1.17
+go 1.21
 
 require (
 	github.com/AlecAivazis/survey/v2 v2.3.4
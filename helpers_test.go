@@ -4,8 +4,8 @@ import "bytes"
 
 func setOutputBuffers(sc *Context) (out, err *bytes.Buffer) {
 	stdoutBuffer := bytes.NewBuffer(make([]byte, 0, 100))
-	sc.stdout = stdoutBuffer
+	sc.SetStdout(stdoutBuffer)
 	stderrBuffer := bytes.NewBuffer(make([]byte, 0, 100))
-	sc.stderr = stderrBuffer
+	sc.SetStderr(stderrBuffer)
 	return stdoutBuffer, stderrBuffer
 }
@@ -0,0 +1,236 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// LockMode defines how AcquireLock waits for a lock held by another process.
+type LockMode int
+
+const (
+	// LockBlocking waits until the lock is available.
+	LockBlocking LockMode = iota
+	// LockNonBlocking fails immediately if the lock is held.
+	LockNonBlocking
+	// LockTimeout waits until the lock is available or the timeout is reached.
+	LockTimeout
+)
+
+// lockPollInterval is the time between attempts to get a lock in LockTimeout mode.
+var lockPollInterval = 50 * time.Millisecond
+
+// ErrLockingUnsupported is returned by AcquireLock if the filesystem of the
+// Context does not support file locking.
+var ErrLockingUnsupported = errors.New("filesystem does not support file locking")
+
+// LockOptions defines details of acquiring a lock.
+type LockOptions struct {
+	Mode    LockMode
+	Timeout time.Duration
+}
+
+// LockedError is returned by AcquireLock if the lock is held by another process.
+type LockedError struct {
+	Path string
+	PID  int
+	// Stale is true if the process that wrote its PID to the lock file is
+	// not running anymore, but the lock is still held (e.g. by one of its
+	// child processes).
+	Stale bool
+}
+
+func (e LockedError) Error() string {
+	switch {
+	case e.PID == 0:
+		return fmt.Sprintf("`%s` is locked", e.Path)
+	case e.Stale:
+		return fmt.Sprintf("`%s` is locked, the process %d that acquired it is not running anymore", e.Path, e.PID)
+	default:
+		return fmt.Sprintf("`%s` is locked by process %d", e.Path, e.PID)
+	}
+}
+
+// Lock is a lock acquired using AcquireLock.
+type Lock struct {
+	mu      sync.Mutex
+	path    string
+	file    afero.File
+	cleanup *cleanupRegistry
+	// StalePID is the PID of a process that did not release the lock
+	// before it ended, 0 if the lock was released properly.
+	StalePID int
+}
+
+// SetLockDir sets the directory lock files with relative names are created in.
+// It defaults to the temporary directory of the system.
+func (c *Context) SetLockDir(dir string) {
+	dir = c.AbsPath(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lockDir = dir
+}
+
+// LockDir returns the directory lock files with relative names are created in.
+func (c *Context) LockDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lockDir == "" {
+		return os.TempDir()
+	}
+	return c.lockDir
+}
+
+// AcquireLock acquires an exclusive lock (see flock(2)) on the given file,
+// which is created if needed. Relative names are resolved in LockDir. Use it
+// to make sure only one instance of a script is running at a time. The PID of
+// the current process is written to the lock file.
+//
+// The lock is released using Release, by Cleanup or at the latest when the
+// program exits. If options are nil, AcquireLock waits until the lock is
+// available.
+func (c *Context) AcquireLock(name string, options *LockOptions) (*Lock, error) {
+	if options == nil {
+		options = &LockOptions{Mode: LockBlocking}
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.LockDir(), name)
+	}
+	err := c.EnsurePathForFile(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := c.Fs().OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := file.(interface{ Fd() uintptr })
+	if !ok {
+		file.Close()
+		return nil, ErrLockingUnsupported
+	}
+	fd := int(f.Fd())
+
+	switch options.Mode {
+	case LockBlocking:
+		err = flock(fd, syscall.LOCK_EX)
+	case LockNonBlocking:
+		err = flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	case LockTimeout:
+		deadline := time.Now().Add(options.Timeout)
+		for {
+			err = flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+			if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(lockPollInterval)
+		}
+	default:
+		err = fmt.Errorf("unknown lock mode %d", options.Mode)
+	}
+	if err != nil {
+		pid := readLockPID(file)
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, &LockedError{Path: path, PID: pid, Stale: pid != 0 && !processRunning(pid)}
+		}
+		return nil, err
+	}
+
+	lock := &Lock{
+		path:    path,
+		file:    file,
+		cleanup: c.cleanup,
+	}
+	if pid := readLockPID(file); pid != 0 && pid != os.Getpid() && !processRunning(pid) {
+		lock.StalePID = pid
+	}
+	err = writeLockPID(file)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	c.cleanup.addLock(lock)
+	return lock, nil
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Release releases the lock. Releasing a lock more than once is a no-op.
+func (l *Lock) Release() error {
+	l.cleanup.removeLock(l)
+	return l.release()
+}
+
+func (l *Lock) release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	// the file itself is kept, removing it would allow others waiting for
+	// the lock on the old file to get it while it is acquired on a new one
+	l.file.Truncate(0)
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// flock retries on EINTR, which can happen while waiting for the lock.
+func flock(fd int, how int) error {
+	for {
+		err := syscall.Flock(fd, how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func readLockPID(file afero.File) int {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func writeLockPID(file afero.File) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// processRunning checks if a process with the given PID exists.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,97 @@
+package script
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLock(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir := t.TempDir()
+	sc.SetLockDir(filepath.Join(dir, "locks"))
+	assert.Equal(filepath.Join(dir, "locks"), sc.LockDir())
+
+	lock, err := sc.AcquireLock("my-script.lock", &LockOptions{Mode: LockNonBlocking})
+	assert.Nil(err)
+	assert.Equal(filepath.Join(dir, "locks", "my-script.lock"), lock.Path())
+	content, _ := ioutil.ReadFile(lock.Path())
+	assert.Equal(strconv.Itoa(os.Getpid())+"\n", string(content))
+
+	_, err = sc.AcquireLock("my-script.lock", &LockOptions{Mode: LockNonBlocking})
+	assert.IsType(&LockedError{}, err)
+	assert.Equal(os.Getpid(), err.(*LockedError).PID)
+	assert.False(err.(*LockedError).Stale)
+
+	assert.Nil(lock.Release())
+	assert.Nil(lock.Release())
+	assert.Empty(sc.cleanup.locks)
+	lock, err = sc.AcquireLock(lock.Path(), nil)
+	assert.Nil(err)
+	assert.Equal(0, lock.StalePID)
+
+	// released on cleanup
+	sc.Cleanup()
+	lock, err = sc.AcquireLock(lock.Path(), &LockOptions{Mode: LockNonBlocking})
+	assert.Nil(err)
+	lock.Release()
+
+	_, err = sc.AcquireLock(lock.Path(), &LockOptions{Mode: LockMode(42)})
+	assert.EqualError(err, "unknown lock mode 42")
+	lock, err = sc.AcquireLock(lock.Path(), &LockOptions{Mode: LockNonBlocking})
+	assert.Nil(err)
+	lock.Release()
+}
+
+func TestAcquireLockTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetLockDir(t.TempDir())
+	lock, err := sc.AcquireLock("timeout.lock", nil)
+	assert.Nil(err)
+
+	start := time.Now()
+	_, err = sc.AcquireLock("timeout.lock", &LockOptions{Mode: LockTimeout, Timeout: 100 * time.Millisecond})
+	assert.IsType(&LockedError{}, err)
+	assert.GreaterOrEqual(time.Since(start), 100*time.Millisecond)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Release()
+	}()
+	second, err := sc.AcquireLock("timeout.lock", &LockOptions{Mode: LockTimeout, Timeout: 5 * time.Second})
+	assert.Nil(err)
+	second.Release()
+}
+
+func TestAcquireLockStale(t *testing.T) {
+	sc := processContext()
+	sc.SetLockDir(t.TempDir())
+
+	// a process that is not running anymore
+	pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	pid := pr.ProcessState.Pid()
+	ioutil.WriteFile(filepath.Join(sc.LockDir(), "stale.lock"), []byte(strconv.Itoa(pid)), 0644)
+
+	lock, err := sc.AcquireLock("stale.lock", &LockOptions{Mode: LockNonBlocking})
+	assert.Nil(t, err)
+	assert.Equal(t, pid, lock.StalePID)
+	lock.Release()
+}
+
+func TestAcquireLockUnsupported(t *testing.T) {
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	_, err := sc.AcquireLock("/locks/memory.lock", nil)
+	assert.Equal(t, ErrLockingUnsupported, err)
+}
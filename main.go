@@ -0,0 +1,150 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/jojomi/go-script/v2/print"
+)
+
+// CommandError is returned by ProcessResult.Check for commands that were not
+// successful.
+type CommandError struct {
+	Command  string
+	ExitCode int
+	Signal   syscall.Signal
+}
+
+func (e CommandError) Error() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("command `%s` was terminated by signal: %s", e.Command, e.Signal)
+	}
+	return fmt.Sprintf("command `%s` failed with exit code %d", e.Command, e.ExitCode)
+}
+
+// Check returns a CommandError if the process denoted by this struct was not
+// successful, nil otherwise.
+func (pr *ProcessResult) Check() error {
+	if pr.Successful() {
+		return nil
+	}
+	exitCode, _ := pr.ExitCode()
+	return &CommandError{
+		Command:  pr.Command(),
+		ExitCode: exitCode,
+		Signal:   pr.Signal(),
+	}
+}
+
+// SetDryRun enables or disables dry run mode. In dry run mode commands are not
+// executed, see Execute and Spawn. Dry run mode only applies to commands,
+// filesystem operations like CopyDir, MoveDir, WriteFileAtomic or EnsureLine
+// still change files. Check DryRun before calling them if needed.
+func (c *Context) SetDryRun(dryRun bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dryRun = dryRun
+}
+
+// DryRun returns if dry run mode is enabled.
+func (c *Context) DryRun() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dryRun
+}
+
+// Args returns the command line arguments of the program that were not
+// handled by Main.
+func (c *Context) Args() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.args
+}
+
+// Main runs f as the main function of a script and exits the program
+// afterwards. It handles the following command line arguments, all others are
+// available using Context.Args:
+//
+//	--verbose, -v  trace every operation to stderr like `set -x` in bash
+//	--dry-run      trace commands instead of executing them, see
+//	               Context.SetDryRun; files are still changed
+//
+// Panics are printed, the Context is cleaned up on return, panic and SIGINT or
+// SIGTERM (see Context.Cleanup), and errors returned by f are printed. The
+// exit code of the program is the one of a failed command if f returns a
+// CommandError or exec.ExitError, 1 for other errors and 2 for panics.
+func Main(f func(ctx *Context) error) {
+	exitFunc(runMain(NewContext(), os.Args[1:], f))
+}
+
+func runMain(ctx *Context, args []string, f func(ctx *Context) error) (exitCode int) {
+	args, verbose, dryRun := parseMainArgs(args)
+	ctx.mu.Lock()
+	ctx.args = args
+	ctx.mu.Unlock()
+	ctx.SetDryRun(dryRun)
+	if verbose || dryRun {
+		ctx.SetTracer(NewShellTracer(ctx.Stderr()))
+	}
+
+	stop := ctx.TrapSignals()
+	defer stop()
+	defer func() {
+		if r := recover(); r != nil {
+			print.ErrorCrossTo(ctx.Stderr(), " ", r, "\n")
+			exitCode = 2
+		}
+		ctx.Cleanup()
+	}()
+
+	err := f(ctx)
+	if err != nil {
+		print.ErrorCrossTo(ctx.Stderr(), " ", ctx.MaskSecrets(err.Error()), "\n")
+		return exitCodeForError(err)
+	}
+	return 0
+}
+
+func parseMainArgs(input []string) (args []string, verbose, dryRun bool) {
+	args = make([]string, 0, len(input))
+	for i, arg := range input {
+		switch arg {
+		case "--verbose", "-v":
+			verbose = true
+		case "--dry-run":
+			dryRun = true
+		case "--":
+			return append(args, input[i+1:]...), verbose, dryRun
+		default:
+			args = append(args, arg)
+		}
+	}
+	return
+}
+
+func exitCodeForError(err error) int {
+	var (
+		commandError *CommandError
+		exitError    *exec.ExitError
+		code         = 1
+	)
+	switch {
+	case errors.As(err, &commandError):
+		code = commandError.ExitCode
+		if commandError.Signal != 0 {
+			code = 128 + int(commandError.Signal)
+		}
+	case errors.As(err, &exitError):
+		code = exitError.ExitCode()
+		if status, ok := exitError.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			code = 128 + int(status.Signal())
+		}
+	}
+	if code <= 0 {
+		return 1
+	}
+	return code
+}
@@ -0,0 +1,111 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMain(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	_, stderr := setOutputBuffers(sc)
+	cleanedUp := false
+	code := runMain(sc, []string{"first", "--verbose", "second"}, func(ctx *Context) error {
+		ctx.AddCleanup(func() { cleanedUp = true })
+		assert.Equal([]string{"first", "second"}, ctx.Args())
+		assert.False(ctx.DryRun())
+		_, err := ctx.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+		return err
+	})
+	assert.Equal(0, code)
+	assert.True(cleanedUp)
+	assert.Equal("+ ./bin basic-output\n", stderr.String())
+}
+
+func TestRunMainExitCodes(t *testing.T) {
+	tests := []struct {
+		f        func(ctx *Context) error
+		exitCode int
+		stderr   string
+	}{
+		{
+			f: func(ctx *Context) error {
+				return errors.New("failed")
+			},
+			exitCode: 1,
+			stderr:   "failed",
+		},
+		{
+			f: func(ctx *Context) error {
+				pr, _ := ctx.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+				return fmt.Errorf("deploying: %w", pr.Check())
+			},
+			exitCode: 28,
+			stderr:   "deploying: command `./bin exit-code-error` failed with exit code 28",
+		},
+		{
+			f: func(ctx *Context) error {
+				pr, _ := ctx.ExecuteFullySilent(interactiveCommand("kill -9 $$"))
+				return pr.Check()
+			},
+			exitCode: 137,
+			stderr:   "was terminated by signal: killed",
+		},
+		{
+			f: func(ctx *Context) error {
+				panic("panicking")
+			},
+			exitCode: 2,
+			stderr:   "panicking",
+		},
+	}
+
+	for _, test := range tests {
+		sc := processContext()
+		_, stderr := setOutputBuffers(sc)
+		code := runMain(sc, []string{}, test.f)
+		assert.Equal(t, test.exitCode, code)
+		assert.Contains(t, stderr.String(), test.stderr)
+	}
+}
+
+func TestRunMainDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	_, stderr := setOutputBuffers(sc)
+	code := runMain(sc, []string{"--dry-run", "--", "--verbose"}, func(ctx *Context) error {
+		assert.True(ctx.DryRun())
+		assert.Equal([]string{"--verbose"}, ctx.Args())
+		pr, err := ctx.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+		assert.Nil(err)
+		assert.True(pr.Successful())
+		return pr.Check()
+	})
+	assert.Equal(0, code)
+	assert.Equal("+ ./bin exit-code-error\n", stderr.String())
+}
+
+func TestDryRunDetached(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	sc.SetDryRun(true)
+	pr, err := sc.ExecuteDetachedFullySilent(LocalCommandFrom("./bin sleep"))
+	assert.Nil(err)
+	assert.Nil(pr.Process)
+	sc.WaitCmd(pr)
+	assert.Nil(pr.ProcessError)
+	assert.True(pr.Successful())
+
+	// nothing is prepared, so sudo is not probed for other users
+	t.Setenv("PATH", t.TempDir())
+	pr, err = sc.AsUser("user-does-not-exist").ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.Nil(err)
+	assert.True(pr.Successful())
+	assert.Equal("id -un", pr.Command())
+}
@@ -1,6 +1,7 @@
 package print
 
 import (
+	"io"
 	"os"
 
 	"github.com/fatih/color"
@@ -104,10 +105,15 @@ func Errorln(input ...interface{}) {
 
 // ErrorCross func
 func ErrorCross(inputSuffix ...interface{}) {
+	ErrorCrossTo(os.Stderr, inputSuffix...)
+}
+
+// ErrorCrossTo func
+func ErrorCrossTo(w io.Writer, inputSuffix ...interface{}) {
 	input := make([]interface{}, len(inputSuffix)+1)
 	input[0] = ErrorChar
 	for index, i := range inputSuffix {
 		input[index+1] = i
 	}
-	printError(os.Stderr, input...)
+	printError(w, input...)
 }
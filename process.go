@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // ProcessResult contains the results of a process execution be it successful or not.
@@ -22,16 +23,36 @@ type ProcessResult struct {
 	ProcessError error
 	stdoutBuffer *bytes.Buffer
 	stderrBuffer *bytes.Buffer
+	secrets      []string
+	writers      []*secretWriter
+	command      string
+	args         []string
+	dir          string
+	env          []string
+	startTime    time.Time
+	endTime      time.Time
+	userTime     time.Duration
+	systemTime   time.Duration
+	maxRSS       int64
+	// set for results not backed by a process (decoded from JSON or dry runs)
+	hasExitCode bool
+	exitCode    int
+	signal      syscall.Signal
 }
 
 // CommandConfig defines details of command execution.
 type CommandConfig struct {
+	// RawStdout and RawStderr connect the output of the command to the
+	// Context's writers without capturing it. If secrets are registered, the
+	// output is masked and a terminal is no longer connected directly.
 	RawStdout    bool
 	RawStderr    bool
 	OutputStdout bool
 	OutputStderr bool
 	ConnectStdin bool
 	Detach       bool
+	// User is the name of the user to run the command as, see Context.AsUser.
+	User string
 }
 
 // NewProcessResult creates a new empty ProcessResult
@@ -45,7 +66,7 @@ func NewProcessResult() *ProcessResult {
 // Output returns a string representation of the output of the process denoted
 // by this struct.
 func (pr *ProcessResult) Output() string {
-	return pr.stdoutBuffer.String()
+	return maskSecrets(pr.secrets, pr.stdoutBuffer.String())
 }
 
 // TrimmedOutput returns a string representation of the output of the process denoted
@@ -57,7 +78,7 @@ func (pr *ProcessResult) TrimmedOutput() string {
 // Error returns a string representation of the stderr output of the process denoted
 // by this struct.
 func (pr *ProcessResult) Error() string {
-	return pr.stderrBuffer.String()
+	return maskSecrets(pr.secrets, pr.stderrBuffer.String())
 }
 
 // Successful returns true iff the process denoted by this struct was run
@@ -96,6 +117,9 @@ func (pr *ProcessResult) ExitCode() (int, error) {
 		waitStatus = exitError.Sys().(syscall.WaitStatus)
 	} else {
 		if pr.ProcessState == nil {
+			if pr.hasExitCode {
+				return pr.exitCode, nil
+			}
 			return -1, errors.New("no exit code available")
 		}
 		waitStatus = pr.ProcessState.Sys().(syscall.WaitStatus)
@@ -209,28 +233,87 @@ func (c *Context) MustExecuteFullySilent(command Command) (pr *ProcessResult) {
 }
 
 // Execute executes a system command according to given CommandConfig.
+// In dry run mode the command is only reported to the Tracer and a successful
+// ProcessResult without output is returned.
 func (c *Context) Execute(cc CommandConfig, command Command) (pr *ProcessResult, err error) {
-	cmd, pr := c.prepareCommand(cc, command)
+	if c.DryRun() {
+		return c.dryRunResult(command), nil
+	}
+
+	cmd, pr, err := c.prepareCommand(cc, command)
+	if err != nil {
+		return
+	}
 
 	if cc.Detach {
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
 		}
+		cmd.SysProcAttr.Setpgid = true
 	}
 
-	err = cmd.Start()
+	err = c.startCommand(cmd, pr)
 	if err != nil {
 		return
 	}
-	pr.Process = cmd.Process
 
-	if !cc.Detach {
+	if cc.Detach {
+		c.cleanup.addDetached(pr)
+	} else {
 		c.WaitCmd(pr)
 	}
 
 	return
 }
 
+// dryRunResult reports a command to the Tracer instead of running it and
+// returns a successful ProcessResult without output or process.
+func (c *Context) dryRunResult(command Command) *ProcessResult {
+	pr := NewProcessResult()
+	c.mu.RLock()
+	pr.dir = c.workingDir
+	pr.env = c.customEnv()
+	pr.secrets = c.secrets
+	c.mu.RUnlock()
+	pr.command = maskSecrets(pr.secrets, command.String())
+	pr.args = maskAll(pr.secrets, append([]string{command.Binary()}, command.Args()...))
+
+	c.trace(TraceEvent{
+		Op:      TraceExecStart,
+		Command: pr.command,
+		Dir:     pr.dir,
+		Env:     maskAll(pr.secrets, pr.env),
+	})
+	pr.startTime = time.Now()
+	pr.endTime = pr.startTime
+	pr.hasExitCode = true
+	return pr
+}
+
+// startCommand starts a prepared command and reports it to the Tracer.
+func (c *Context) startCommand(cmd *exec.Cmd, pr *ProcessResult) error {
+	event := TraceEvent{
+		Command: pr.command,
+		Dir:     cmd.Dir,
+		Env:     maskAll(pr.secrets, pr.env),
+	}
+	event.Op = TraceExecStart
+	c.trace(event)
+
+	pr.startTime = time.Now()
+	err := cmd.Start()
+	if err != nil {
+		err = c.maskError(err)
+		event.Op = TraceExec
+		event.ExitCode = -1
+		event.Err = err
+		c.trace(event)
+		return err
+	}
+	pr.Process = cmd.Process
+	return nil
+}
+
 // ExecuteDetachedDebug executes a system command, stdout and stderr are piped.
 // The command is executed in the background (detached).
 func (c *Context) ExecuteDetachedDebug(command Command) (pr *ProcessResult, err error) {
@@ -266,43 +349,101 @@ func (c *Context) ExecuteDetachedFullySilent(command Command) (pr *ProcessResult
 	return
 }
 
-func (c Context) prepareCommand(cc CommandConfig, command Command) (*exec.Cmd, *ProcessResult) {
+func (c *Context) prepareCommand(cc CommandConfig, command Command) (*exec.Cmd, *ProcessResult, error) {
 	pr := NewProcessResult()
 
 	cmd := exec.Command(command.Binary(), command.Args()...)
 	pr.Cmd = cmd
 
+	// snapshot the Context, it may be changed while the command is running
+	c.mu.RLock()
 	cmd.Dir = c.workingDir
-	cmd.Env = c.GetFullEnv()
+	pr.dir = c.workingDir
+	pr.env = c.customEnv()
+	cmd.Env = append(os.Environ(), pr.env...)
+	pr.secrets = c.secrets
+	stdout, stderr, stdin := c.syncWriter(c.stdout), c.syncWriter(c.stderr), c.stdin
+	username := c.user
+	probes := c.sudoProbes
+	c.mu.RUnlock()
+	pr.command = maskSecrets(pr.secrets, command.String())
+	pr.args = maskAll(pr.secrets, append([]string{command.Binary()}, command.Args()...))
+
+	if cc.User != "" {
+		username = cc.User
+	}
+	if username != "" {
+		err := setCommandUser(cmd, username, pr.env, probes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	if cc.RawStdout {
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = pr.maskedWriter(stdout)
 	} else {
 		if !cc.OutputStdout {
 			cmd.Stdout = pr.stdoutBuffer
 		} else {
-			cmd.Stdout = io.MultiWriter(c.stdout, pr.stdoutBuffer)
+			cmd.Stdout = io.MultiWriter(pr.maskedWriter(stdout), pr.stdoutBuffer)
 		}
 	}
 	if cc.RawStderr {
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = pr.maskedWriter(stderr)
 	} else {
 		if !cc.OutputStderr {
 			cmd.Stderr = pr.stderrBuffer
 		} else {
-			cmd.Stderr = io.MultiWriter(c.stderr, pr.stderrBuffer)
+			cmd.Stderr = io.MultiWriter(pr.maskedWriter(stderr), pr.stderrBuffer)
 		}
 	}
 
 	if cc.ConnectStdin {
-		cmd.Stdin = c.stdin
+		cmd.Stdin = stdin
 	}
-	return cmd, pr
+	return cmd, pr, nil
 }
 
 // WaitCmd waits for a command to be finished (useful on detached processes).
-func (c Context) WaitCmd(pr *ProcessResult) {
+// Results of dry runs are finished already and returned as they are.
+func (c *Context) WaitCmd(pr *ProcessResult) {
+	if pr.Cmd == nil {
+		return
+	}
 	err := pr.Cmd.Wait()
 	pr.ProcessState = pr.Cmd.ProcessState
 	pr.ProcessError = err
+	pr.recordUsage()
+	c.cleanup.removeDetached(pr)
+	for _, w := range pr.writers {
+		w.Flush()
+	}
+
+	exitCode, _ := pr.ExitCode()
+	c.timings.add(CommandTiming{
+		Command:  pr.command,
+		Dir:      pr.Cmd.Dir,
+		Start:    pr.startTime,
+		Duration: pr.Duration(),
+		ExitCode: exitCode,
+	})
+	c.trace(TraceEvent{
+		Time:     pr.startTime,
+		Op:       TraceExec,
+		Command:  pr.command,
+		Dir:      pr.Cmd.Dir,
+		Env:      maskAll(pr.secrets, pr.env),
+		ExitCode: exitCode,
+		Duration: pr.Duration(),
+	})
+}
+
+// maskedWriter wraps a writer so that secrets known to this result are masked.
+func (pr *ProcessResult) maskedWriter(w io.Writer) io.Writer {
+	if len(pr.secrets) == 0 {
+		return w
+	}
+	sw := newSecretWriter(w, pr.secrets)
+	pr.writers = append(pr.writers, sw)
+	return sw
 }
@@ -1,7 +1,9 @@
 package script
 
 import (
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,6 +74,17 @@ func TestProcessStdin(t *testing.T) {
 	assert.Equal(t, input+"\n", pr.Error())
 }
 
+func TestProcessExecuteRaw(t *testing.T) {
+	sc := processContext()
+	stdout, stderr := setOutputBuffers(sc)
+
+	pr, err := sc.ExecuteRaw(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	assert.Equal(t, "", pr.Output())
+	assert.Equal(t, basicOutputStdout, stdout.String())
+	assert.Equal(t, basicOutputStderr, stderr.String())
+}
+
 /* COMMAND EXECUTION */
 
 func TestProcessRunFailure(t *testing.T) {
@@ -170,6 +183,36 @@ func TestProcessExecuteDetachedFullySilent(t *testing.T) {
 	assert.Equal(t, basicOutputStderr, stderr.String())
 }
 
+/* CONCURRENCY (run with -race) */
+
+func TestProcessConcurrentExecute(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := processContext()
+	setOutputBuffers(sc)
+	wd := sc.WorkingDir()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+			assert.Nil(t, err)
+			assert.Equal(t, basicOutputStdout, pr.Output())
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			sc.SetEnv(fmt.Sprintf("KEY_%d", i), "value")
+			sc.AddSecret(fmt.Sprintf("secret-%d", i))
+			assert.Nil(t, sc.SetWorkingDir(wd))
+			sc.GetFullEnv()
+			sc.Clone().SetEnv("KEY", "value")
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, sc.GetCustomEnv(), 20)
+}
+
 /* COMMAND HANDLING */
 
 func TestProcessCommandExists(t *testing.T) {
@@ -3,6 +3,8 @@ package script
 import (
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/gernest/wow"
 	"github.com/gernest/wow/spin"
@@ -12,26 +14,27 @@ import (
 // ActivityIndicatorCustom returns an activity indicator as specified.
 // Use Start() to start and Stop() to stop it.
 // See: https://godoc.org/github.com/gernest/wow
-func (c Context) ActivityIndicatorCustom(text string, typ spin.Name) *wow.Wow {
-	w := wow.New(c.stdout, spin.Get(typ), " "+text)
+func (c *Context) ActivityIndicatorCustom(text string, typ spin.Name) *wow.Wow {
+	w := wow.New(c.Stdout(), spin.Get(typ), " "+text)
 	return w
 }
 
 // ActivityIndicator returns an activity indicator with specified text and default animation.
-func (c Context) ActivityIndicator(text string) *wow.Wow {
+func (c *Context) ActivityIndicator(text string) *wow.Wow {
 	return c.ActivityIndicatorCustom(text, spin.Dots)
 }
 
 // ProgressReader returns a reader that is able to visualize read progress.
-func (c Context) ProgressReader(reader io.Reader, size int) (io.Reader, *pb.ProgressBar) {
+func (c *Context) ProgressReader(reader io.Reader, size int) (io.Reader, *pb.ProgressBar) {
 	bar := pb.New(size).SetUnits(pb.U_BYTES)
+	bar.Output = c.Stdout()
 
 	// create proxy reader
 	return bar.NewProxyReader(reader), bar
 }
 
 // ProgressFileReader returns a reader that is able to visualize read progress for a file.
-func (c Context) ProgressFileReader(f *os.File) (io.Reader, *pb.ProgressBar, error) {
+func (c *Context) ProgressFileReader(f *os.File) (io.Reader, *pb.ProgressBar, error) {
 	size, err := f.Stat()
 	if err != nil {
 		return nil, nil, err
@@ -39,3 +42,20 @@ func (c Context) ProgressFileReader(f *os.File) (io.Reader, *pb.ProgressBar, err
 	r, bar := c.ProgressReader(f, int(size.Size()))
 	return r, bar, nil
 }
+
+// CopyProgressBar returns a ProgressFunc for copy and move operations that
+// visualizes their progress using a progress bar. The bar is started on the
+// first call, use Finish() on it when the operation is done.
+func (c *Context) CopyProgressBar() (ProgressFunc, *pb.ProgressBar) {
+	bar := pb.New64(0).SetUnits(pb.U_BYTES)
+	bar.Output = c.Stdout()
+	var once sync.Once
+	return func(progress CopyProgress) {
+		once.Do(func() {
+			bar.SetTotal64(progress.BytesTotal)
+			bar.Start()
+		})
+		bar.Postfix(" " + filepath.Base(progress.File))
+		bar.Set64(progress.BytesDone)
+	}, bar
+}
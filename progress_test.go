@@ -1,9 +1,12 @@
 package script
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,3 +20,32 @@ func TestActivityIndicator(t *testing.T) {
 	assert.Equal(t, "", stdout.String())
 	assert.Equal(t, "", stderr.String())
 }
+
+func TestProgressReader(t *testing.T) {
+	sc := NewContext()
+	stdout, _ := setOutputBuffers(sc)
+
+	input := "progress reader content"
+	r, bar := sc.ProgressReader(strings.NewReader(input), len(input))
+	bar.Start()
+	data, err := ioutil.ReadAll(r)
+	bar.Finish()
+	assert.Nil(t, err)
+	assert.Equal(t, input, string(data))
+	assert.NotEmpty(t, stdout.String())
+}
+
+func TestCopyProgressBar(t *testing.T) {
+	sc := NewContext()
+	stdout, _ := setOutputBuffers(sc)
+	sc.SetFs(afero.NewMemMapFs())
+	afero.WriteFile(sc.Fs(), "/src/file", []byte("progress bar content"), 0644)
+
+	progress, bar := sc.CopyProgressBar()
+	err := sc.CopyFileOpts("/src/file", "/dst", CopyFileOptions{Progress: progress})
+	bar.Finish()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), bar.Get())
+	assert.Equal(t, int64(20), bar.Total)
+	assert.Contains(t, stdout.String(), "file")
+}
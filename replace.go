@@ -0,0 +1,133 @@
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Replacement defines a single replacement done by ReplaceInFileOpts.
+type Replacement struct {
+	// Search is a regular expression, or a plain string if Literal is set.
+	Search string
+	// Replace is the replacement for every match. Unless Literal is set,
+	// $1 and ${name} are expanded to submatches like in
+	// regexp.Regexp.Expand.
+	Replace string
+	// ReplaceFunc is used instead of Replace if it is set. It gets the match
+	// followed by its submatches.
+	ReplaceFunc func(submatches []string) string
+	// Literal treats Search and Replace as plain strings.
+	Literal bool
+}
+
+// ReplaceOptions defines details of ReplaceInFileOpts.
+type ReplaceOptions struct {
+	// Replacements are applied in order, each one working on the result of
+	// the previous ones.
+	Replacements []Replacement
+	// LineScoped matches every line separately, without its line break.
+	LineScoped bool
+	// RequireMatch makes ReplaceInFileOpts fail with a NoMatchError if any of
+	// the replacements does not match. The file is not changed then.
+	RequireMatch bool
+}
+
+// NoMatchError is returned by ReplaceInFileOpts if a required match was not found.
+type NoMatchError struct {
+	Filename string
+	Search   string
+}
+
+func (e NoMatchError) Error() string {
+	return fmt.Sprintf("`%s` not found in `%s`", e.Search, e.Filename)
+}
+
+// ReplaceInFileOpts is a variant of ReplaceInFile that takes options. It
+// returns the total number of replacements done. The file is only written
+// if its content changed.
+func (c *Context) ReplaceInFileOpts(filename string, options ReplaceOptions) (count int, err error) {
+	absoluteFilename := c.AbsPath(filename)
+	defer c.traceOp(TraceReplaceInFile, time.Now(), &err, absoluteFilename)
+
+	regexps := make([]*regexp.Regexp, len(options.Replacements))
+	for i, r := range options.Replacements {
+		search := r.Search
+		if r.Literal {
+			search = regexp.QuoteMeta(search)
+		}
+		regexps[i], err = regexp.Compile(search)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	b, err := afero.ReadFile(c.Fs(), absoluteFilename)
+	if err != nil {
+		return 0, err
+	}
+	content := string(b)
+
+	for i, r := range options.Replacements {
+		var n int
+		if options.LineScoped {
+			lines := strings.SplitAfter(content, "\n")
+			for l, line := range lines {
+				text := strings.TrimSuffix(line, "\n")
+				replaced, lineCount := r.apply(regexps[i], text)
+				lines[l] = replaced + line[len(text):]
+				n += lineCount
+			}
+			content = strings.Join(lines, "")
+		} else {
+			content, n = r.apply(regexps[i], content)
+		}
+		if n == 0 && options.RequireMatch {
+			return 0, &NoMatchError{Filename: absoluteFilename, Search: r.Search}
+		}
+		count += n
+	}
+
+	if content == string(b) {
+		return count, nil
+	}
+	err = c.writeFileAtomic(absoluteFilename, []byte(content), WriteFileOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// apply replaces all matches of re in s and returns the result and the
+// number of matches.
+func (r Replacement) apply(re *regexp.Regexp, s string) (string, int) {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, 0
+	}
+	var result []byte
+	last := 0
+	for _, m := range matches {
+		result = append(result, s[last:m[0]]...)
+		switch {
+		case r.ReplaceFunc != nil:
+			submatches := make([]string, len(m)/2)
+			for n := range submatches {
+				if m[2*n] >= 0 {
+					submatches[n] = s[m[2*n]:m[2*n+1]]
+				}
+			}
+			result = append(result, r.ReplaceFunc(submatches)...)
+		case r.Literal:
+			result = append(result, r.Replace...)
+		default:
+			result = re.ExpandString(result, r.Replace, s, m)
+		}
+		last = m[1]
+	}
+	result = append(result, s[last:]...)
+	return string(result), len(matches)
+}
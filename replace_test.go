@@ -0,0 +1,109 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceInFileOpts(t *testing.T) {
+	tests := []struct {
+		content  string
+		options  ReplaceOptions
+		count    int
+		expected string
+	}{
+		// ordered replacements
+		{
+			content: "port: 80\nhost: a.com\n",
+			options: ReplaceOptions{Replacements: []Replacement{
+				{Search: `port: \d+`, Replace: `port: 443`},
+				{Search: `443`, Replace: `8443`},
+				{Search: `(\w+)\.com`, Replace: `$1.org`},
+			}},
+			count:    3,
+			expected: "port: 8443\nhost: a.org\n",
+		},
+		// literal
+		{
+			content: "price: $1.00 (x.y)\n",
+			options: ReplaceOptions{Replacements: []Replacement{
+				{Search: `$1.00`, Replace: `$2.00`, Literal: true},
+				{Search: `(x.y)`, Replace: `(z)`, Literal: true},
+			}},
+			count:    2,
+			expected: "price: $2.00 (z)\n",
+		},
+		// replace function
+		{
+			content: "name: joe\nname: ann\n",
+			options: ReplaceOptions{Replacements: []Replacement{
+				{Search: `name: (\w+)`, ReplaceFunc: func(submatches []string) string {
+					return "name: " + strings.ToUpper(submatches[1])
+				}},
+			}},
+			count:    2,
+			expected: "name: JOE\nname: ANN\n",
+		},
+		// line scoped
+		{
+			content: "a\nb\nc",
+			options: ReplaceOptions{
+				Replacements: []Replacement{{Search: `^(.*)$`, Replace: `- $1`}},
+				LineScoped:   true,
+			},
+			count:    3,
+			expected: "- a\n- b\n- c",
+		},
+		// no match
+		{
+			content: "a\n",
+			options: ReplaceOptions{Replacements: []Replacement{
+				{Search: `b`, Replace: `c`},
+			}},
+			count:    0,
+			expected: "a\n",
+		},
+	}
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.cfg"
+
+	for _, test := range tests {
+		afero.WriteFile(sc.Fs(), filename, []byte(test.content), 0644)
+		count, err := sc.ReplaceInFileOpts(filename, test.options)
+		assert.Nil(t, err)
+		assert.Equal(t, test.count, count)
+		content, _ := afero.ReadFile(sc.Fs(), filename)
+		assert.Equal(t, test.expected, string(content))
+	}
+}
+
+func TestReplaceInFileOptsRequireMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	filename := "/etc/app.cfg"
+	afero.WriteFile(sc.Fs(), filename, []byte("a\n"), 0644)
+
+	count, err := sc.ReplaceInFileOpts(filename, ReplaceOptions{
+		Replacements: []Replacement{
+			{Search: `a`, Replace: `b`},
+			{Search: `missing`, Replace: `c`},
+		},
+		RequireMatch: true,
+	})
+	assert.Equal(0, count)
+	assert.Equal(&NoMatchError{Filename: filename, Search: "missing"}, err)
+	content, _ := afero.ReadFile(sc.Fs(), filename)
+	assert.Equal("a\n", string(content))
+
+	_, err = sc.ReplaceInFileOpts(filename, ReplaceOptions{
+		Replacements: []Replacement{{Search: `\p`}},
+	})
+	assert.NotNil(err)
+}
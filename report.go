@@ -0,0 +1,207 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// Command returns the command line of the process denoted by this struct.
+func (pr *ProcessResult) Command() string {
+	return pr.command
+}
+
+// Args returns the binary and the arguments of the process denoted by this struct.
+func (pr *ProcessResult) Args() []string {
+	return pr.args
+}
+
+// Dir returns the working dir the process denoted by this struct was run in.
+func (pr *ProcessResult) Dir() string {
+	return pr.dir
+}
+
+// Env returns the environment variables set by the Context for the process
+// denoted by this struct (not including the ones inherited from this process).
+func (pr *ProcessResult) Env() []string {
+	return maskAll(pr.secrets, pr.env)
+}
+
+// Signal returns the signal that terminated the process denoted by this struct
+// or 0 if it was not terminated by a signal.
+func (pr *ProcessResult) Signal() syscall.Signal {
+	if pr.ProcessState == nil {
+		return pr.signal
+	}
+	waitStatus, ok := pr.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return 0
+	}
+	return waitStatus.Signal()
+}
+
+type jsonProcessResult struct {
+	Command      string    `json:"command"`
+	Args         []string  `json:"args"`
+	Dir          string    `json:"dir"`
+	Env          []string  `json:"env"`
+	Stdout       string    `json:"stdout"`
+	Stderr       string    `json:"stderr"`
+	ExitCode     int       `json:"exit_code"`
+	Signal       int       `json:"signal,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	DurationMs   float64   `json:"duration_ms"`
+	UserTimeMs   float64   `json:"user_time_ms"`
+	SystemTimeMs float64   `json:"system_time_ms"`
+	MaxRSS       int64     `json:"max_rss"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Secrets are masked in the result.
+func (pr *ProcessResult) MarshalJSON() ([]byte, error) {
+	exitCode, _ := pr.ExitCode()
+	j := jsonProcessResult{
+		Command:      pr.Command(),
+		Args:         pr.Args(),
+		Dir:          pr.Dir(),
+		Env:          pr.Env(),
+		Stdout:       pr.Output(),
+		Stderr:       pr.Error(),
+		ExitCode:     exitCode,
+		Signal:       int(pr.Signal()),
+		Start:        pr.StartTime(),
+		End:          pr.EndTime(),
+		DurationMs:   toMilliseconds(pr.Duration()),
+		UserTimeMs:   toMilliseconds(pr.UserTime()),
+		SystemTimeMs: toMilliseconds(pr.SystemTime()),
+		MaxRSS:       pr.MaxRSS(),
+	}
+	if pr.ProcessError != nil {
+		j.Error = maskSecrets(pr.secrets, pr.ProcessError.Error())
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The resulting ProcessResult has
+// no Cmd, Process or ProcessState, but all accessors work as usual.
+func (pr *ProcessResult) UnmarshalJSON(data []byte) error {
+	var j jsonProcessResult
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	*pr = ProcessResult{
+		stdoutBuffer: bytes.NewBufferString(j.Stdout),
+		stderrBuffer: bytes.NewBufferString(j.Stderr),
+		command:      j.Command,
+		args:         j.Args,
+		dir:          j.Dir,
+		env:          j.Env,
+		startTime:    j.Start,
+		endTime:      j.End,
+		userTime:     fromMilliseconds(j.UserTimeMs),
+		systemTime:   fromMilliseconds(j.SystemTimeMs),
+		maxRSS:       j.MaxRSS,
+		hasExitCode:  true,
+		exitCode:     j.ExitCode,
+		signal:       syscall.Signal(j.Signal),
+	}
+	if j.Error != "" {
+		pr.ProcessError = errors.New(j.Error)
+	}
+	return nil
+}
+
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func fromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+// WriteJUnitReport writes a JUnit XML report to w, containing one test case
+// per ProcessResult. Unsuccessful processes are reported as failures.
+func WriteJUnitReport(w io.Writer, suiteName string, results []*ProcessResult) error {
+	suite := junitTestSuite{
+		Name:      suiteName,
+		Tests:     len(results),
+		TestCases: make([]junitTestCase, 0, len(results)),
+	}
+	var total time.Duration
+	for _, pr := range results {
+		if suite.Timestamp == "" && !pr.StartTime().IsZero() {
+			suite.Timestamp = pr.StartTime().Format("2006-01-02T15:04:05")
+		}
+		total += pr.Duration()
+		tc := junitTestCase{
+			Name:      pr.Command(),
+			ClassName: pr.Dir(),
+			Time:      formatSeconds(pr.Duration()),
+			SystemOut: pr.Output(),
+			SystemErr: pr.Error(),
+		}
+		if !pr.Successful() {
+			suite.Failures++
+			exitCode, _ := pr.ExitCode()
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("exit code %d", exitCode),
+				Type:    "ExitCode",
+				Content: pr.Error(),
+			}
+			if pr.ProcessError != nil {
+				tc.Failure.Message = maskSecrets(pr.secrets, pr.ProcessError.Error())
+			}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = formatSeconds(total)
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(suite)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
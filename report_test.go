@@ -0,0 +1,90 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessResultJSON(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	assert := assert.New(t)
+
+	sc := processContext()
+	sc.SetSecretEnv("TOKEN", "whatever")
+	pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(err)
+
+	data, err := json.Marshal(pr)
+	assert.Nil(err)
+	assert.NotContains(string(data), "whatever")
+
+	var decoded ProcessResult
+	err = json.Unmarshal(data, &decoded)
+	assert.Nil(err)
+	assert.Equal("./bin basic-output", decoded.Command())
+	assert.Equal([]string{"./bin", "basic-output"}, decoded.Args())
+	assert.Equal(sc.WorkingDir(), decoded.Dir())
+	assert.Equal([]string{"TOKEN=***"}, decoded.Env())
+	assert.Equal("hello this is me\n***\n", decoded.Output())
+	assert.Equal(basicOutputStderr, decoded.Error())
+	assert.True(decoded.Successful())
+	assert.Equal(pr.StartTime().UnixNano(), decoded.StartTime().UnixNano())
+	// decoded times have no monotonic clock reading
+	assert.Equal(pr.EndTime().Round(0).Sub(pr.StartTime().Round(0)), decoded.Duration())
+	assert.Equal(pr.MaxRSS(), decoded.MaxRSS())
+	assert.Nil(decoded.ProcessError)
+}
+
+func TestProcessResultJSONFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+	assert.Nil(err)
+	data, err := json.Marshal(pr)
+	assert.Nil(err)
+	var decoded ProcessResult
+	assert.Nil(json.Unmarshal(data, &decoded))
+	exitCode, err := decoded.ExitCode()
+	assert.Nil(err)
+	assert.Equal(28, exitCode)
+	assert.False(decoded.Successful())
+	assert.Equal("exit status 28", decoded.ProcessError.Error())
+
+	pr, err = sc.ExecuteFullySilent(LocalCommandFrom(`/bin/sh -c "kill -9 $$"`))
+	assert.Nil(err)
+	assert.Equal(syscall.SIGKILL, pr.Signal())
+	data, err = json.Marshal(pr)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &decoded))
+	assert.Equal(syscall.SIGKILL, decoded.Signal())
+	assert.False(decoded.Successful())
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	pr1, _ := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	pr2, _ := sc.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+
+	var b bytes.Buffer
+	err := WriteJUnitReport(&b, "deploy", []*ProcessResult{pr1, pr2})
+	assert.Nil(err)
+
+	var suite junitTestSuite
+	assert.Nil(xml.Unmarshal(b.Bytes(), &suite))
+	assert.Equal("deploy", suite.Name)
+	assert.Equal(2, suite.Tests)
+	assert.Equal(1, suite.Failures)
+	assert.Len(suite.TestCases, 2)
+	assert.Equal("./bin basic-output", suite.TestCases[0].Name)
+	assert.Equal(basicOutputStdout, suite.TestCases[0].SystemOut)
+	assert.Nil(suite.TestCases[0].Failure)
+	assert.Equal("exit status 28", suite.TestCases[1].Failure.Message)
+}
@@ -0,0 +1,177 @@
+package script
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SecretMask is the string registered secrets are replaced with in any output.
+var SecretMask = "***"
+
+// registeredSecrets are the secrets added to any Context. Commands are not
+// bound to a Context, so LocalCommand.String masks all of them.
+var registeredSecrets struct {
+	sync.RWMutex
+	list []string
+}
+
+// AddSecret registers a value that must never be shown in output controlled by
+// this Context. Captured stdout and stderr, command rendering and errors have
+// every occurrence replaced by SecretMask. LocalCommand.String masks the
+// secrets of all Contexts.
+func (c *Context) AddSecret(value string) {
+	if value == "" {
+		return
+	}
+	c.mu.Lock()
+	c.secrets = withSecret(c.secrets, value)
+	c.mu.Unlock()
+
+	registeredSecrets.Lock()
+	defer registeredSecrets.Unlock()
+	registeredSecrets.list = withSecret(registeredSecrets.list, value)
+}
+
+// withSecret returns the secrets including value. The list is never modified
+// in place, snapshots of it are shared.
+func withSecret(secrets []string, value string) []string {
+	for _, s := range secrets {
+		if s == value {
+			return secrets
+		}
+	}
+	secrets = append(append([]string{}, secrets...), value)
+	// longest first, so a secret containing another one is masked completely
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return secrets
+}
+
+// allSecrets returns a snapshot of the secrets added to any Context.
+func allSecrets() []string {
+	registeredSecrets.RLock()
+	defer registeredSecrets.RUnlock()
+	return registeredSecrets.list
+}
+
+// secretList returns a snapshot of the registered secrets.
+func (c *Context) secretList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.secrets
+}
+
+// SetSecretEnv sets a certain environment variable for this context and
+// registers its value as a secret.
+func (c *Context) SetSecretEnv(key, value string) {
+	c.SetEnv(key, value)
+	c.AddSecret(value)
+}
+
+// MaskSecrets replaces all registered secrets in the input with SecretMask.
+func (c *Context) MaskSecrets(input string) string {
+	return maskSecrets(c.secretList(), input)
+}
+
+// CommandString returns the string representation of a command with all
+// secrets of this Context masked, also for implementations of Command other
+// than LocalCommand.
+func (c *Context) CommandString(command Command) string {
+	return c.MaskSecrets(command.String())
+}
+
+func maskSecrets(secrets []string, input string) string {
+	for _, secret := range secrets {
+		input = strings.ReplaceAll(input, secret, SecretMask)
+	}
+	return input
+}
+
+func maskAll(secrets []string, input []string) []string {
+	masked := make([]string, len(input))
+	for i, e := range input {
+		masked[i] = maskSecrets(secrets, e)
+	}
+	return masked
+}
+
+// maskError returns an error with all secrets masked in its message. The
+// original error is still available using errors.Unwrap.
+func (c *Context) maskError(err error) error {
+	if err == nil {
+		return err
+	}
+	msg := c.MaskSecrets(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &maskedError{err: err, msg: msg}
+}
+
+type maskedError struct {
+	err error
+	msg string
+}
+
+func (e *maskedError) Error() string {
+	return e.msg
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+// secretWriter masks secrets in a stream of data. Output that could be the
+// beginning of a secret is held back until it can be decided, so call Flush
+// when the stream is finished.
+type secretWriter struct {
+	w       io.Writer
+	secrets []string
+	pending string
+}
+
+func newSecretWriter(w io.Writer, secrets []string) *secretWriter {
+	return &secretWriter{
+		w:       w,
+		secrets: secrets,
+	}
+}
+
+func (s *secretWriter) Write(p []byte) (int, error) {
+	masked := maskSecrets(s.secrets, s.pending+string(p))
+	hold := s.partialSecretLength(masked)
+	s.pending = masked[len(masked)-hold:]
+	_, err := io.WriteString(s.w, masked[:len(masked)-hold])
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes all data that was held back.
+func (s *secretWriter) Flush() error {
+	if s.pending == "" {
+		return nil
+	}
+	_, err := io.WriteString(s.w, s.pending)
+	s.pending = ""
+	return err
+}
+
+// partialSecretLength returns the length of the longest suffix of input that
+// is the beginning of a secret.
+func (s *secretWriter) partialSecretLength(input string) int {
+	longest := 0
+	for _, secret := range s.secrets {
+		for l := len(secret) - 1; l > longest; l-- {
+			if strings.HasSuffix(input, secret[:l]) {
+				longest = l
+				break
+			}
+		}
+	}
+	return longest
+}
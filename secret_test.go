@@ -0,0 +1,97 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forgetSecrets removes the secrets registered by a test, so they are not
+// masked in the commands of other tests.
+func forgetSecrets() {
+	registeredSecrets.Lock()
+	defer registeredSecrets.Unlock()
+	registeredSecrets.list = nil
+}
+
+func TestMaskSecrets(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := NewContext()
+	assert.Equal(t, "my password", sc.MaskSecrets("my password"))
+
+	sc.AddSecret("password")
+	sc.AddSecret("")
+	sc.AddSecret("pass")
+	assert.Equal(t, "my ***", sc.MaskSecrets("my password"))
+	assert.Equal(t, "*** and ***", sc.MaskSecrets("pass and password"))
+}
+
+func TestSetSecretEnv(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := NewContext()
+	sc.SetSecretEnv("TOKEN", "s3cr3t")
+	assert.Equal(t, "s3cr3t", sc.GetCustomEnvValue("TOKEN"))
+	assert.Equal(t, "TOKEN=***", sc.MaskSecrets("TOKEN=s3cr3t"))
+}
+
+func TestCommandStringMasked(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	c := LocalCommandFrom("mysql -u root -phunter2")
+	assert.Equal(t, "mysql -u root -phunter2", c.String())
+
+	sc := NewContext()
+	sc.AddSecret("hunter2")
+	assert.Equal(t, "mysql -u root -p***", sc.CommandString(c))
+	assert.Equal(t, "mysql -u root -p***", c.String())
+}
+
+func TestRawOutputMasked(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := processContext()
+	stdout, stderr := setOutputBuffers(sc)
+	sc.AddSecret("this is")
+	sc.AddSecret("abc")
+
+	_, err := sc.ExecuteRaw(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello *** me\nwhatever\n", stdout.String())
+	assert.Equal(t, "\"***\"\n", stderr.String())
+}
+
+func TestProcessOutputMasked(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := processContext()
+	stdout, stderr := setOutputBuffers(sc)
+	sc.AddSecret("this is")
+	sc.AddSecret("abc")
+
+	pr, err := sc.ExecuteDebug(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello *** me\nwhatever\n", pr.Output())
+	assert.Equal(t, "\"***\"\n", pr.Error())
+	assert.Equal(t, "hello *** me\nwhatever\n", stdout.String())
+	assert.Equal(t, "\"***\"\n", stderr.String())
+}
+
+func TestErrorMasked(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	sc := processContext()
+	sc.AddSecret("not-existing")
+	_, err := sc.ExecuteFullySilent(LocalCommandFrom(nonExistingBinary))
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "not-existing")
+	assert.NotNil(t, errors.Unwrap(err))
+}
+
+func TestSecretWriterSplitWrites(t *testing.T) {
+	var b bytes.Buffer
+	w := newSecretWriter(&b, []string{"secret"})
+	w.Write([]byte("my se"))
+	assert.Equal(t, "my ", b.String())
+	w.Write([]byte("cret is sec"))
+	assert.Equal(t, "my *** is ", b.String())
+	w.Flush()
+	assert.Equal(t, "my *** is sec", b.String())
+}
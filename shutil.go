@@ -2,10 +2,14 @@
 package script
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spf13/afero"
 )
@@ -63,8 +67,47 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
-// CopyFile copies data from src to dst
+func isSymlink(fs afero.Fs, path string) bool {
+	lstater, ok := fs.(afero.Lstater)
+	if !ok {
+		return false
+	}
+	fi, _, err := lstater.LstatIfPossible(path)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+// copySymlink creates a symlink at dst pointing to the target of the symlink src.
+func copySymlink(fs afero.Fs, src, dst string) error {
+	reader, ok := fs.(afero.LinkReader)
+	if !ok {
+		return &os.LinkError{Op: "readlink", Old: src, New: dst, Err: afero.ErrNoReadlink}
+	}
+	linker, ok := fs.(afero.Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: src, New: dst, Err: afero.ErrNoSymlink}
+	}
+	target, err := reader.ReadlinkIfPossible(src)
+	if err != nil {
+		return err
+	}
+	err = fs.Remove(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return linker.SymlinkIfPossible(target, dst)
+}
+
+// CopyFile copies data from src to dst. If followSymlinks is false and src is
+// a symlink, dst is created as a symlink to the same target.
 func CopyFile(fs afero.Fs, src, dst string, followSymlinks bool) error {
+	return copyFile(fs, src, dst, followSymlinks, nil)
+}
+
+func copyFile(fs afero.Fs, src, dst string, followSymlinks bool, state *copyState) error {
+	if !followSymlinks && isSymlink(fs, src) {
+		return copySymlink(fs, src, dst)
+	}
+
 	if samefile(fs, src, dst) {
 		return &SameFileError{src, dst}
 	}
@@ -100,8 +143,11 @@ func CopyFile(fs afero.Fs, src, dst string, followSymlinks bool) error {
 	}
 	defer fdst.Close()
 
-	size, err := io.Copy(fdst, fsrc)
+	size, err := io.Copy(fdst, state.reader(src, fsrc))
 	if err != nil {
+		// do not leave a partial copy behind, e.g. when canceled
+		fdst.Close()
+		fs.Remove(dst)
 		return err
 	}
 
@@ -112,8 +158,13 @@ func CopyFile(fs afero.Fs, src, dst string, followSymlinks bool) error {
 	return nil
 }
 
-// CopyMode copies mode bits from src to dst.
+// CopyMode copies mode bits from src to dst. If followSymlinks is false and
+// src is a symlink, nothing is done, because the mode of a symlink can not be
+// changed.
 func CopyMode(fs afero.Fs, src, dst string, followSymlinks bool) error {
+	if !followSymlinks && isSymlink(fs, src) {
+		return nil
+	}
 	srcStat, err := fs.Stat(src)
 	if err != nil {
 		return err
@@ -158,14 +209,193 @@ func Copy(fs afero.Fs, src, dst string, followSymlinks bool) (string, error) {
 	return dst, nil
 }
 
+// OverwritePolicy defines which existing files CopyTree replaces when
+// merging into an existing destination.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces all existing files.
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever keeps all existing files.
+	OverwriteNever
+	// OverwriteIfNewer replaces files older than their source.
+	OverwriteIfNewer
+	// OverwriteIfDifferent replaces files differing from their source in size
+	// or content.
+	OverwriteIfDifferent
+)
+
+// CopyReport lists the destination paths of the files and directories
+// CopyTree worked on.
+type CopyReport struct {
+	Created []string
+	Updated []string
+	Skipped []string
+	Removed []string
+}
+
+// CopyProgress describes the progress of a copy operation.
+type CopyProgress struct {
+	// File is the source file currently copied.
+	File       string
+	BytesDone  int64
+	BytesTotal int64
+}
+
+// ProgressFunc is called repeatedly while files are copied.
+type ProgressFunc func(progress CopyProgress)
+
+// copyState tracks progress and cancellation of a copy operation. A nil
+// *copyState does neither.
+type copyState struct {
+	ctx      context.Context
+	progress ProgressFunc
+	done     int64
+	total    int64
+}
+
+func newCopyState(ctx context.Context, progress ProgressFunc, total int64) *copyState {
+	if ctx == nil && progress == nil {
+		return nil
+	}
+	return &copyState{ctx: ctx, progress: progress, total: total}
+}
+
+func (s *copyState) canceled() error {
+	if s == nil || s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Err()
+}
+
+func (s *copyState) advance(file string, n int64) {
+	if s == nil || s.progress == nil {
+		return
+	}
+	s.done += n
+	s.progress(CopyProgress{File: file, BytesDone: s.done, BytesTotal: s.total})
+}
+
+// reader wraps r to report the bytes read from the given file and to stop
+// reading when the operation is canceled.
+func (s *copyState) reader(file string, r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &copyStateReader{state: s, file: file, r: r}
+}
+
+type copyStateReader struct {
+	state *copyState
+	file  string
+	r     io.Reader
+}
+
+func (r *copyStateReader) Read(p []byte) (int, error) {
+	if err := r.state.canceled(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.state.advance(r.file, int64(n))
+	}
+	return n, err
+}
+
+// treeSize returns the total size of the files below dir.
+func treeSize(fs afero.Fs, dir string, symlinks bool) (int64, error) {
+	var total int64
+	err := afero.Walk(fs, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 && !symlinks {
+			// dangling symlinks are an error later or skipped
+			if target, err := fs.Stat(path); err == nil {
+				info = target
+			}
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// PreserveOptions defines which metadata of the source is applied to the copy
+// of a file or directory. It is not applied to symlinks.
+type PreserveOptions struct {
+	// Times preserves access and modification time.
+	Times bool
+	// Owner preserves user and group, which usually requires root.
+	Owner bool
+	// Mode preserves all permission bits including setuid, setgid and
+	// sticky, regardless of the umask and after changing the owner.
+	Mode bool
+}
+
+// PreserveAll preserves all metadata, like `cp -a` does.
+var PreserveAll = PreserveOptions{Times: true, Owner: true, Mode: true}
+
+// preserve applies the metadata of srcInfo to dst.
+func (p PreserveOptions) preserve(fs afero.Fs, srcInfo os.FileInfo, dst string) error {
+	stat, _ := srcInfo.Sys().(*syscall.Stat_t)
+	if p.Owner && stat != nil {
+		err := fs.Chown(dst, int(stat.Uid), int(stat.Gid))
+		if err != nil {
+			return err
+		}
+	}
+	if p.Mode {
+		err := fs.Chmod(dst, srcInfo.Mode())
+		if err != nil {
+			return err
+		}
+	}
+	if p.Times {
+		err := fs.Chtimes(dst, accessTime(srcInfo), srcInfo.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type CopyTreeOptions struct {
 	CopyFunction func(afero.Fs, string, string, bool) (string, error)
 	Ignore       func(string, []os.FileInfo) []string
+	// Symlinks copies symlinks as symlinks instead of the files and
+	// directories they point to.
+	Symlinks bool
+	// IgnoreDanglingSymlinks skips symlinks pointing to nothing instead of
+	// failing, if Symlinks is not set.
+	IgnoreDanglingSymlinks bool
+	// Preserve defines which metadata is kept.
+	Preserve PreserveOptions
+	// Merge copies into an existing destination instead of failing with
+	// AlreadyExistsError.
+	Merge bool
+	// Overwrite defines which existing files are replaced when merging.
+	Overwrite OverwritePolicy
+	// Delete removes files and directories from the destination that do not
+	// exist in the source, like rsync --delete does. Ignored names are kept.
+	Delete bool
+	// Report is filled with the changes if it is not nil.
+	Report *CopyReport
+	// Progress is called while copying. It is called after every file if a
+	// CopyFunction is set, and repeatedly while copying a file otherwise.
+	Progress ProgressFunc
+	// Ctx stops copying when it is canceled. Files copied already are kept.
+	Ctx context.Context
+
+	state *copyState
 }
 
 // Recursively copy a directory tree.
 //
-// The destination directory must not already exist.
+// The destination directory must not already exist, unless the Merge option
+// is set.
 //
 // If the optional Symlinks flag is true, symbolic links in the
 // source tree result in symbolic links in the destination tree; if
@@ -174,8 +404,8 @@ type CopyTreeOptions struct {
 // exist, an error will be returned.
 //
 // You can set the optional IgnoreDanglingSymlinks flag to true if you
-// want to silence this error. Notice that this has no effect on
-// platforms that don't support os.Symlink.
+// want to silence this error. Notice that Symlinks has no effect on
+// filesystems that don't support symlinks.
 //
 // The optional ignore argument is a callable. If given, it
 // is called with the `src` parameter, which is the directory
@@ -194,12 +424,29 @@ type CopyTreeOptions struct {
 // destination path as arguments. By default, Copy() is used, but any
 // function that supports the same signature (like Copy2() when it
 // exists) can be used.
+//
+// If the optional Ctx is canceled, CopyTree stops and returns its error.
 func CopyTree(fs afero.Fs, src, dst string, options *CopyTreeOptions) error {
 	if options == nil {
-		options = &CopyTreeOptions{
-			Ignore:       nil,
-			CopyFunction: Copy,
+		options = &CopyTreeOptions{}
+	}
+	if options.state == nil && (options.Ctx != nil || options.Progress != nil) {
+		var total int64
+		if options.Progress != nil {
+			var err error
+			total, err = treeSize(fs, src, options.Symlinks)
+			if err != nil {
+				return err
+			}
 		}
+		options.state = newCopyState(options.Ctx, options.Progress, total)
+		defer func() {
+			options.state = nil
+		}()
+	}
+	err := options.state.canceled()
+	if err != nil {
+		return err
 	}
 
 	srcFileInfo, err := fs.Stat(src)
@@ -211,9 +458,16 @@ func CopyTree(fs afero.Fs, src, dst string, options *CopyTreeOptions) error {
 		return &NotADirectoryError{src}
 	}
 
-	_, err = fs.Open(dst)
-	if !os.IsNotExist(err) {
+	dstFileInfo, err := fs.Stat(dst)
+	switch {
+	case os.IsNotExist(err):
+		dstFileInfo = nil
+	case err != nil:
+		return err
+	case !options.Merge:
 		return &AlreadyExistsError{dst}
+	case !dstFileInfo.IsDir():
+		return &NotADirectoryError{dst}
 	}
 
 	entries, err := afero.ReadDir(fs, src)
@@ -225,6 +479,9 @@ func CopyTree(fs afero.Fs, src, dst string, options *CopyTreeOptions) error {
 	if err != nil {
 		return err
 	}
+	if dstFileInfo == nil {
+		options.Report.add(copyCreated, dst)
+	}
 
 	ignoredNames := []string{}
 	if options.Ignore != nil {
@@ -238,22 +495,217 @@ func CopyTree(fs afero.Fs, src, dst string, options *CopyTreeOptions) error {
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 
+		err = options.state.canceled()
+		if err != nil {
+			return err
+		}
+		if options.Symlinks && entry.Mode()&os.ModeSymlink != 0 {
+			err = copyTreeFile(fs, entry, srcPath, dstPath, options)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		entryFileInfo, err := fs.Stat(srcPath)
+		if os.IsNotExist(err) && options.IgnoreDanglingSymlinks && entry.Mode()&os.ModeSymlink != 0 {
+			options.Report.add(copySkipped, dstPath)
+			continue
+		}
 		if err != nil {
 			return err
 		}
 
 		if entryFileInfo.IsDir() {
+			err = removeIfOtherType(fs, entryFileInfo, dstPath, options)
+			if err != nil {
+				return err
+			}
 			err = CopyTree(fs, srcPath, dstPath, options)
 			if err != nil {
 				return err
 			}
 		} else {
-			_, err = options.CopyFunction(fs, srcPath, dstPath, false)
+			err = copyTreeFile(fs, entryFileInfo, srcPath, dstPath, options)
 			if err != nil {
 				return err
 			}
 		}
 	}
+
+	if options.Delete {
+		err = deleteExtraneous(fs, entries, ignoredNames, dst, options.Report)
+		if err != nil {
+			return err
+		}
+	}
+	// after copying the content, which changes the modification time
+	return options.Preserve.preserve(fs, srcFileInfo, dst)
+}
+
+// removeIfOtherType removes a file that is in the way of a directory.
+func removeIfOtherType(fs afero.Fs, srcInfo os.FileInfo, dstPath string, options *CopyTreeOptions) error {
+	dstInfo, err := fs.Stat(dstPath)
+	if err != nil || dstInfo.IsDir() == srcInfo.IsDir() {
+		return nil
+	}
+	if options.Overwrite == OverwriteNever {
+		return &AlreadyExistsError{dstPath}
+	}
+	err = fs.RemoveAll(dstPath)
+	if err != nil {
+		return err
+	}
+	options.Report.add(copyRemoved, dstPath)
+	return nil
+}
+
+// copyTreeFile copies a single file of a tree according to the overwrite policy.
+func copyTreeFile(fs afero.Fs, srcInfo os.FileInfo, srcPath, dstPath string, options *CopyTreeOptions) error {
+	dstInfo, err := fs.Stat(dstPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exists := err == nil
+	if exists {
+		overwrite, err := shouldOverwrite(fs, options.Overwrite, srcInfo, dstInfo, srcPath, dstPath)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			options.Report.add(copySkipped, dstPath)
+			if srcInfo.Mode().IsRegular() {
+				options.state.advance(srcPath, srcInfo.Size())
+			}
+			return nil
+		}
+		if dstInfo.IsDir() {
+			err = fs.RemoveAll(dstPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	followSymlinks := !options.Symlinks
+	if options.CopyFunction != nil {
+		_, err = options.CopyFunction(fs, srcPath, dstPath, followSymlinks)
+		if err == nil && srcInfo.Mode().IsRegular() {
+			options.state.advance(srcPath, srcInfo.Size())
+		}
+	} else {
+		// like Copy, but reporting progress while copying
+		err = copyFile(fs, srcPath, dstPath, followSymlinks, options.state)
+		if err == nil {
+			err = CopyMode(fs, srcPath, dstPath, followSymlinks)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if srcInfo.Mode()&os.ModeSymlink == 0 {
+		err = options.Preserve.preserve(fs, srcInfo, dstPath)
+		if err != nil {
+			return err
+		}
+	}
+	if exists {
+		options.Report.add(copyUpdated, dstPath)
+	} else {
+		options.Report.add(copyCreated, dstPath)
+	}
 	return nil
 }
+
+func shouldOverwrite(fs afero.Fs, policy OverwritePolicy, srcInfo, dstInfo os.FileInfo, srcPath, dstPath string) (bool, error) {
+	switch policy {
+	case OverwriteNever:
+		return false, nil
+	case OverwriteIfNewer:
+		return srcInfo.ModTime().After(dstInfo.ModTime()), nil
+	case OverwriteIfDifferent:
+		if dstInfo.IsDir() || srcInfo.Size() != dstInfo.Size() {
+			return true, nil
+		}
+		same, err := sameContent(fs, srcPath, dstPath)
+		return !same, err
+	default:
+		return true, nil
+	}
+}
+
+// sameContent compares the SHA-256 hashes of two files.
+func sameContent(fs afero.Fs, a, b string) (bool, error) {
+	hashA, err := fileHash(fs, a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := fileHash(fs, b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
+
+func fileHash(fs afero.Fs, filename string) ([]byte, error) {
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// deleteExtraneous removes the entries of dst not in entries.
+func deleteExtraneous(fs afero.Fs, entries []os.FileInfo, ignoredNames []string, dst string, report *CopyReport) error {
+	dstEntries, err := afero.ReadDir(fs, dst)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	for _, entry := range dstEntries {
+		if names[entry.Name()] || stringInSlice(entry.Name(), ignoredNames) {
+			continue
+		}
+		dstPath := filepath.Join(dst, entry.Name())
+		err = fs.RemoveAll(dstPath)
+		if err != nil {
+			return err
+		}
+		report.add(copyRemoved, dstPath)
+	}
+	return nil
+}
+
+type copyChange int
+
+const (
+	copyCreated copyChange = iota
+	copyUpdated
+	copySkipped
+	copyRemoved
+)
+
+func (r *CopyReport) add(change copyChange, path string) {
+	if r == nil {
+		return
+	}
+	switch change {
+	case copyCreated:
+		r.Created = append(r.Created, path)
+	case copyUpdated:
+		r.Updated = append(r.Updated, path)
+	case copySkipped:
+		r.Skipped = append(r.Skipped, path)
+	case copyRemoved:
+		r.Removed = append(r.Removed, path)
+	}
+}
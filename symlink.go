@@ -0,0 +1,301 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SymlinkCycleError is returned by ResolveSymlinks if resolving a symlink
+// leads back to itself or a directory containing it.
+type SymlinkCycleError struct {
+	Path string
+}
+
+func (e SymlinkCycleError) Error() string {
+	return fmt.Sprintf("`%s` is part of a symlink cycle", e.Path)
+}
+
+// BrokenSymlinkError is returned by ResolveSymlinks if a symlink points to
+// nothing.
+type BrokenSymlinkError struct {
+	Path   string
+	Target string
+}
+
+func (e BrokenSymlinkError) Error() string {
+	return fmt.Sprintf("`%s` points to `%s`, which does not exist", e.Path, e.Target)
+}
+
+// SymlinkOutsideTreeError is returned by ResolveSymlinks if a symlink points
+// outside the directory and RestrictToTree is set.
+type SymlinkOutsideTreeError struct {
+	Path   string
+	Target string
+}
+
+func (e SymlinkOutsideTreeError) Error() string {
+	return fmt.Sprintf("`%s` points to `%s` outside the tree", e.Path, e.Target)
+}
+
+// ResolvedSymlink is a symlink replaced by ResolveSymlinks.
+type ResolvedSymlink struct {
+	// Path is the path of the symlink.
+	Path string
+	// Target is the real path of the file or directory it pointed to, with
+	// all symlinks resolved.
+	Target string
+}
+
+// SymlinkReport lists the symlinks ResolveSymlinks replaced.
+type SymlinkReport struct {
+	Resolved []ResolvedSymlink
+}
+
+// ResolveSymlinksOptions defines details of resolving symlinks.
+type ResolveSymlinksOptions struct {
+	// RestrictToTree makes ResolveSymlinks fail with a
+	// SymlinkOutsideTreeError if a symlink points outside the directory.
+	RestrictToTree bool
+	// Report is filled with the replaced symlinks if it is not nil.
+	Report *SymlinkReport
+}
+
+// ResolveSymlinks resolves symlinks in a directory, so that it is self-contained.
+// All symlinks are replaced with copies of the files and directories they point
+// to, including chains of symlinks and symlinks inside symlinked directories.
+// Nothing is done if the directory does not exist.
+func (c *Context) ResolveSymlinks(dir string) error {
+	return c.ResolveSymlinksOpts(dir, ResolveSymlinksOptions{})
+}
+
+// ResolveSymlinksOpts is a variant of ResolveSymlinks that takes options.
+func (c *Context) ResolveSymlinksOpts(dir string, options ResolveSymlinksOptions) (err error) {
+	dir = c.AbsPath(dir)
+	defer c.traceOp(TraceResolveSymlink, time.Now(), &err, dir)
+	// directory does not exist -> nothing to do
+	if !c.DirExists(dir) {
+		return nil
+	}
+	fs := c.Fs()
+	reader, ok := fs.(afero.LinkReader)
+	if !ok {
+		// no symlinks possible
+		return nil
+	}
+
+	root, err := evalSymlinks(fs, reader, dir)
+	if err != nil {
+		return err
+	}
+	r := &symlinkResolver{
+		fs:      fs,
+		reader:  reader,
+		root:    root,
+		options: options,
+	}
+	return r.resolveDir(dir, []string{root})
+}
+
+type symlinkResolver struct {
+	fs      afero.Fs
+	reader  afero.LinkReader
+	root    string
+	options ResolveSymlinksOptions
+}
+
+// resolveDir replaces the symlinks in dir, which is part of the tree. ancestors
+// are the real paths of dir and the directories containing it.
+func (r *symlinkResolver) resolveDir(dir string, ancestors []string) error {
+	entries, err := afero.ReadDir(r.fs, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.Mode()&os.ModeSymlink != 0:
+			err = r.resolveLink(path, path, path, ancestors)
+		case entry.IsDir():
+			real := filepath.Join(ancestors[len(ancestors)-1], entry.Name())
+			err = r.resolveDir(path, append(ancestors[:len(ancestors):len(ancestors)], real))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveLink replaces the symlink at dst with a copy of what the symlink at
+// link points to, both are the same for symlinks in the tree. path is where
+// dst ends up and is used for errors and the report.
+func (r *symlinkResolver) resolveLink(link, dst, path string, ancestors []string) error {
+	target, err := evalSymlinks(r.fs, r.reader, link)
+	if errors.Is(err, os.ErrNotExist) {
+		linkTarget, _ := r.reader.ReadlinkIfPossible(link)
+		return &BrokenSymlinkError{Path: path, Target: linkTarget}
+	}
+	if err != nil {
+		return err
+	}
+	if r.options.RestrictToTree && !isWithin(target, r.root) {
+		return &SymlinkOutsideTreeError{Path: path, Target: target}
+	}
+
+	if link == dst {
+		err = r.replaceLink(link, target, ancestors)
+	} else {
+		// inside a copy that is not in place yet
+		err = r.copyResolved(target, dst, path, ancestors)
+	}
+	if err != nil {
+		return err
+	}
+	if r.options.Report != nil {
+		r.options.Report.Resolved = append(r.options.Report.Resolved, ResolvedSymlink{Path: path, Target: target})
+	}
+	return nil
+}
+
+// replaceLink copies target next to the symlink link and replaces the link
+// with it only if the whole copy succeeded.
+func (r *symlinkResolver) replaceLink(link, target string, ancestors []string) error {
+	dir, base := filepath.Split(link)
+	tmpDir, err := afero.TempDir(r.fs, dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer r.fs.RemoveAll(tmpDir)
+
+	tmp := filepath.Join(tmpDir, base)
+	err = r.copyResolved(target, tmp, link, ancestors)
+	if err != nil {
+		return err
+	}
+	// a directory can not replace a symlink by renaming
+	info, err := r.fs.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = r.fs.Remove(link)
+		if err != nil {
+			return err
+		}
+	}
+	return r.fs.Rename(tmp, link)
+}
+
+// copyResolved copies the file or directory src to dst, resolving all
+// symlinks in it relative to src. path is where dst ends up. ancestors are the real paths of the
+// directories being copied or resolved, copying one of them or a directory
+// containing one of them would never end.
+func (r *symlinkResolver) copyResolved(src, dst, path string, ancestors []string) error {
+	info, err := r.fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		_, err = Copy(r.fs, src, dst, true)
+		return err
+	}
+	for _, ancestor := range ancestors {
+		if isWithin(ancestor, src) {
+			return &SymlinkCycleError{Path: path}
+		}
+	}
+
+	err = r.fs.MkdirAll(dst, info.Mode())
+	if err != nil {
+		return err
+	}
+	ancestors = append(ancestors[:len(ancestors):len(ancestors)], src)
+	entries, err := afero.ReadDir(r.fs, src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+		path := filepath.Join(path, entry.Name())
+		if entry.Mode()&os.ModeSymlink != 0 {
+			err = r.resolveLink(srcPath, dstPath, path, ancestors)
+		} else {
+			err = r.copyResolved(srcPath, dstPath, path, ancestors)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isWithin checks if path is dir or inside of it, both must be clean and
+// absolute.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// maxSymlinks is the number of symlinks evalSymlinks follows before it
+// assumes a cycle, like filepath.EvalSymlinks.
+const maxSymlinks = 255
+
+// evalSymlinks is like filepath.EvalSymlinks, but works on the given
+// filesystem and detects cycles. path must be absolute.
+func evalSymlinks(fs afero.Fs, reader afero.LinkReader, path string) (string, error) {
+	lstater, ok := fs.(afero.Lstater)
+	if !ok {
+		return path, nil
+	}
+	var (
+		resolved  = string(os.PathSeparator)
+		remaining = splitPath(path)
+		links     = 0
+	)
+	for len(remaining) > 0 {
+		element := remaining[0]
+		remaining = remaining[1:]
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, element)
+		info, _, err := lstater.LstatIfPossible(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &SymlinkCycleError{Path: path}
+		}
+		target, err := reader.ReadlinkIfPossible(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = string(os.PathSeparator)
+		}
+		remaining = append(splitPath(target), remaining...)
+	}
+	return resolved, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, string(os.PathSeparator))
+}
@@ -0,0 +1,131 @@
+package script
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSymlinksChains(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	sc.SetWorkingDir(dir)
+	os.MkdirAll(filepath.Join(dir, "data", "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "data", "sub", "file"), []byte("content"), 0644)
+	os.Symlink("sub/file", filepath.Join(dir, "data", "sub-link"))
+	os.MkdirAll(filepath.Join(dir, "bundle"), 0755)
+	// chain: link -> middle -> data/sub/file
+	os.Symlink("middle", filepath.Join(dir, "bundle", "link"))
+	os.Symlink("../data/sub/file", filepath.Join(dir, "bundle", "middle"))
+	// symlinked directory containing a relative symlink
+	os.Symlink(filepath.Join(dir, "data"), filepath.Join(dir, "bundle", "data"))
+
+	report := &SymlinkReport{}
+	err := sc.ResolveSymlinksOpts("bundle", ResolveSymlinksOptions{Report: report})
+	assert.Nil(err)
+
+	for _, name := range []string{"link", "middle", "data/sub/file", "data/sub-link"} {
+		path := filepath.Join(dir, "bundle", name)
+		info, err := os.Lstat(path)
+		if assert.Nil(err, name) {
+			assert.True(info.Mode().IsRegular(), name)
+		}
+		content, _ := os.ReadFile(path)
+		assert.Equal("content", string(content), name)
+	}
+	info, _ := os.Lstat(filepath.Join(dir, "bundle", "data"))
+	assert.True(info.IsDir())
+	// the source of the copies is unchanged
+	info, _ = os.Lstat(filepath.Join(dir, "data", "sub-link"))
+	assert.True(info.Mode()&os.ModeSymlink != 0)
+
+	file := filepath.Join(dir, "data", "sub", "file")
+	assert.ElementsMatch([]ResolvedSymlink{
+		{Path: filepath.Join(dir, "bundle", "data", "sub-link"), Target: file},
+		{Path: filepath.Join(dir, "bundle", "data"), Target: filepath.Join(dir, "data")},
+		{Path: filepath.Join(dir, "bundle", "link"), Target: file},
+		{Path: filepath.Join(dir, "bundle", "middle"), Target: file},
+	}, report.Resolved)
+
+	// nothing left to do
+	report = &SymlinkReport{}
+	assert.Nil(sc.ResolveSymlinksOpts("bundle", ResolveSymlinksOptions{Report: report}))
+	assert.Empty(report.Resolved)
+}
+
+func TestResolveSymlinksErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	sc.SetWorkingDir(dir)
+	os.WriteFile(filepath.Join(dir, "outside"), []byte("content"), 0644)
+
+	// cycle through a parent directory
+	os.MkdirAll(filepath.Join(dir, "cycle", "sub"), 0755)
+	os.Symlink("..", filepath.Join(dir, "cycle", "sub", "parent"))
+	err := sc.ResolveSymlinks("cycle")
+	var cycleErr *SymlinkCycleError
+	assert.True(errors.As(err, &cycleErr))
+	assert.Equal(filepath.Join(dir, "cycle", "sub", "parent"), cycleErr.Path)
+
+	// link to the parent of the tree
+	os.MkdirAll(filepath.Join(dir, "tree-up"), 0755)
+	os.Symlink("..", filepath.Join(dir, "tree-up", "up"))
+	err = sc.ResolveSymlinks("tree-up")
+	assert.True(errors.As(err, &cycleErr))
+	assert.Equal(filepath.Join(dir, "tree-up", "up"), cycleErr.Path)
+	entries, _ := os.ReadDir(filepath.Join(dir, "tree-up"))
+	assert.Len(entries, 1)
+	info, _ := os.Lstat(filepath.Join(dir, "tree-up", "up"))
+	assert.True(info.Mode()&os.ModeSymlink != 0)
+
+	// links pointing to each other
+	os.MkdirAll(filepath.Join(dir, "loop"), 0755)
+	os.Symlink("b", filepath.Join(dir, "loop", "a"))
+	os.Symlink("a", filepath.Join(dir, "loop", "b"))
+	err = sc.ResolveSymlinks("loop")
+	assert.True(errors.As(err, &cycleErr))
+
+	// broken link
+	os.MkdirAll(filepath.Join(dir, "broken"), 0755)
+	os.Symlink("missing", filepath.Join(dir, "broken", "link"))
+	err = sc.ResolveSymlinks("broken")
+	var brokenErr *BrokenSymlinkError
+	assert.True(errors.As(err, &brokenErr))
+	assert.Equal(BrokenSymlinkError{Path: filepath.Join(dir, "broken", "link"), Target: "missing"}, *brokenErr)
+
+	// errors deep down keep the link and leave no partial copy
+	os.MkdirAll(filepath.Join(dir, "data", "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "data", "file"), []byte("content"), 0644)
+	os.Symlink("missing", filepath.Join(dir, "data", "sub", "broken"))
+	os.MkdirAll(filepath.Join(dir, "partial"), 0755)
+	os.Symlink("../data", filepath.Join(dir, "partial", "data"))
+	err = sc.ResolveSymlinks("partial")
+	assert.True(errors.As(err, &brokenErr))
+	assert.Equal(filepath.Join(dir, "partial", "data", "sub", "broken"), brokenErr.Path)
+	entries, _ = os.ReadDir(filepath.Join(dir, "partial"))
+	assert.Len(entries, 1)
+	info, _ = os.Lstat(filepath.Join(dir, "partial", "data"))
+	assert.True(info.Mode()&os.ModeSymlink != 0)
+
+	// outside the tree
+	os.MkdirAll(filepath.Join(dir, "tree"), 0755)
+	os.Symlink("../outside", filepath.Join(dir, "tree", "link"))
+	err = sc.ResolveSymlinksOpts("tree", ResolveSymlinksOptions{RestrictToTree: true})
+	var outsideErr *SymlinkOutsideTreeError
+	assert.True(errors.As(err, &outsideErr))
+	assert.Equal(filepath.Join(dir, "outside"), outsideErr.Target)
+	info, _ = os.Lstat(filepath.Join(dir, "tree", "link"))
+	assert.True(info.Mode()&os.ModeSymlink != 0)
+
+	// allowed without restriction
+	assert.Nil(sc.ResolveSymlinks("tree"))
+	content, _ := os.ReadFile(filepath.Join(dir, "tree", "link"))
+	assert.Equal("content", string(content))
+}
@@ -0,0 +1,133 @@
+package script
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// TemplateOptions defines details of rendering a template.
+type TemplateOptions struct {
+	// Perm is used if the destination file does not exist yet, otherwise its
+	// mode and ownership are kept.
+	Perm os.FileMode
+	// Strict makes rendering fail if a key is missing in a map, instead of
+	// rendering "<no value>".
+	Strict bool
+	// Funcs are added to the functions available in the template,
+	// overriding the built-in ones of the same name.
+	Funcs template.FuncMap
+}
+
+// RenderTemplate renders the text/template in file src using data to file dst.
+// Besides the functions predefined by text/template, these are available:
+//
+//	env "KEY"           value of an environment variable, see GetFullEnv
+//	expandHome "~/path" the path with ~ replaced, see MustExpandHome
+//	quote "text"        the text as double-quoted string with escapes
+//	default "x" .Value  .Value if it is not empty, "x" otherwise
+//
+// The file is written atomically and only if its content changed. It returns
+// whether the file was changed.
+func (c *Context) RenderTemplate(src, dst string, data interface{}, perm os.FileMode) (bool, error) {
+	return c.RenderTemplateOpts(src, dst, data, TemplateOptions{Perm: perm})
+}
+
+// RenderTemplateOpts is a variant of RenderTemplate that takes options.
+func (c *Context) RenderTemplateOpts(src, dst string, data interface{}, options TemplateOptions) (changed bool, err error) {
+	src = c.AbsPath(src)
+	dst = c.AbsPath(dst)
+	defer c.traceOp(TraceRenderTemplate, time.Now(), &err, src, dst)
+
+	fs := c.Fs()
+	text, err := afero.ReadFile(fs, src)
+	if err != nil {
+		return false, err
+	}
+	content, err := c.renderTemplate(filepath.Base(src), string(text), data, options)
+	if err != nil {
+		return false, err
+	}
+
+	old, err := afero.ReadFile(fs, dst)
+	if err == nil && bytes.Equal(old, content) {
+		return false, nil
+	}
+	err = c.writeFileAtomic(dst, content, WriteFileOptions{Perm: options.Perm})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RenderTemplateString renders a text/template using data. See RenderTemplate
+// for the functions available.
+func (c *Context) RenderTemplateString(text string, data interface{}) (string, error) {
+	return c.RenderTemplateStringOpts(text, data, TemplateOptions{})
+}
+
+// RenderTemplateStringOpts is a variant of RenderTemplateString that takes
+// options. Perm is ignored.
+func (c *Context) RenderTemplateStringOpts(text string, data interface{}, options TemplateOptions) (string, error) {
+	content, err := c.renderTemplate("template", text, data, options)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (c *Context) renderTemplate(name, text string, data interface{}, options TemplateOptions) ([]byte, error) {
+	t := template.New(name).Funcs(c.templateFuncs()).Funcs(options.Funcs)
+	if options.Strict {
+		t = t.Option("missingkey=error")
+	}
+	t, err := t.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Context) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"env": func(key string) string {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return c.lookupEnv(key)
+		},
+		"expandHome": c.MustExpandHome,
+		"quote":      strconv.Quote,
+		"default": func(def, value interface{}) interface{} {
+			if isEmptyValue(value) {
+				return def
+			}
+			return value
+		},
+	}
+}
+
+// isEmptyValue reports whether value is nil or the zero value of its type or
+// an empty collection.
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
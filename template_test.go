@@ -0,0 +1,84 @@
+package script
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetFs(afero.NewMemMapFs())
+	afero.WriteFile(sc.Fs(), "/tpl/app.cfg.tpl", []byte("name: {{ .Name }}\nport: {{ .Port | default 80 }}\n"), 0644)
+
+	data := map[string]interface{}{"Name": "app"}
+	changed, err := sc.RenderTemplate("/tpl/app.cfg.tpl", "/etc/app.cfg", data, 0600)
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ := afero.ReadFile(sc.Fs(), "/etc/app.cfg")
+	assert.Equal("name: app\nport: 80\n", string(content))
+	info, _ := sc.Fs().Stat("/etc/app.cfg")
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// unchanged
+	changed, err = sc.RenderTemplate("/tpl/app.cfg.tpl", "/etc/app.cfg", data, 0600)
+	assert.Nil(err)
+	assert.False(changed)
+
+	data["Port"] = 8080
+	changed, err = sc.RenderTemplate("/tpl/app.cfg.tpl", "/etc/app.cfg", data, 0600)
+	assert.Nil(err)
+	assert.True(changed)
+	content, _ = afero.ReadFile(sc.Fs(), "/etc/app.cfg")
+	assert.Equal("name: app\nport: 8080\n", string(content))
+
+	// strict
+	_, err = sc.RenderTemplateOpts("/tpl/app.cfg.tpl", "/etc/app.cfg", map[string]interface{}{}, TemplateOptions{Strict: true})
+	assert.NotNil(err)
+
+	_, err = sc.RenderTemplate("/tpl/missing.tpl", "/etc/app.cfg", data, 0600)
+	assert.NotNil(err)
+}
+
+func TestRenderTemplateString(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := NewContext()
+	sc.SetEnv("APP_USER", "joe")
+
+	tests := []struct {
+		text     string
+		data     interface{}
+		expected string
+	}{
+		{`{{ env "APP_USER" }}`, nil, "joe"},
+		{`{{ env "APP_NOT_SET" }}`, nil, ""},
+		{`{{ quote .Text }}`, map[string]string{"Text": `a "b"`}, `"a \"b\""`},
+		{`{{ .Missing | default "x" }}`, map[string]string{}, "x"},
+		{`{{ .List | default "none" }}`, map[string][]string{"List": {}}, "none"},
+		{`{{ .Value | default "x" }}`, map[string]string{"Value": "y"}, "y"},
+		{`{{ expandHome "~/bin" }}`, nil, sc.MustExpandHome("~/bin")},
+	}
+	for _, test := range tests {
+		output, err := sc.RenderTemplateString(test.text, test.data)
+		assert.Nil(err)
+		assert.Equal(test.expected, output)
+	}
+
+	output, err := sc.RenderTemplateStringOpts(`{{ upper .Name }}`, map[string]string{"Name": "joe"}, TemplateOptions{
+		Funcs: template.FuncMap{"upper": strings.ToUpper},
+	})
+	assert.Nil(err)
+	assert.Equal("JOE", output)
+
+	_, err = sc.RenderTemplateStringOpts(`{{ .Name }}`, map[string]string{}, TemplateOptions{Strict: true})
+	assert.NotNil(err)
+	_, err = sc.RenderTemplateString(`{{ .Name `, nil)
+	assert.NotNil(err)
+}
@@ -0,0 +1,130 @@
+package script
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// StartTime returns the time the process denoted by this struct was started.
+func (pr *ProcessResult) StartTime() time.Time {
+	return pr.startTime
+}
+
+// EndTime returns the time the process denoted by this struct was found to be
+// finished. It is the zero time as long as the process has not been waited for.
+func (pr *ProcessResult) EndTime() time.Time {
+	return pr.endTime
+}
+
+// Duration returns the wall-clock time the process denoted by this struct ran.
+// For processes still running, 0 is returned.
+func (pr *ProcessResult) Duration() time.Duration {
+	if pr.endTime.IsZero() {
+		return 0
+	}
+	return pr.endTime.Sub(pr.startTime)
+}
+
+// UserTime returns the user CPU time of the finished process and its children.
+func (pr *ProcessResult) UserTime() time.Duration {
+	return pr.userTime
+}
+
+// SystemTime returns the system CPU time of the finished process and its children.
+func (pr *ProcessResult) SystemTime() time.Duration {
+	return pr.systemTime
+}
+
+// MaxRSS returns the maximum resident set size of the finished process in bytes.
+func (pr *ProcessResult) MaxRSS() int64 {
+	return pr.maxRSS
+}
+
+// recordUsage saves timing and resource usage of the finished process.
+func (pr *ProcessResult) recordUsage() {
+	pr.endTime = time.Now()
+	state := pr.ProcessState
+	if state == nil {
+		return
+	}
+	pr.userTime = state.UserTime()
+	pr.systemTime = state.SystemTime()
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		pr.maxRSS = int64(rusage.Maxrss)
+		// Linux reports kilobytes, macOS bytes
+		if runtime.GOOS != "darwin" {
+			pr.maxRSS *= 1024
+		}
+	}
+}
+
+// CommandTiming describes the execution of a single command in a Context.
+type CommandTiming struct {
+	Command  string
+	Dir      string
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+}
+
+// timingLog collects the timings of all commands run in a Context and its clones.
+type timingLog struct {
+	mu      sync.Mutex
+	timings []CommandTiming
+}
+
+func (l *timingLog) add(timing CommandTiming) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timings = append(l.timings, timing)
+}
+
+func (l *timingLog) list() []CommandTiming {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]CommandTiming{}, l.timings...)
+}
+
+// CommandTimings returns the timings of all finished commands executed in this
+// Context in the order they finished. Clones share their timings with the
+// Context they were created from.
+func (c *Context) CommandTimings() []CommandTiming {
+	return c.timings.list()
+}
+
+// SlowestCommands returns the timings of the n slowest commands executed in
+// this Context, slowest first. Use n < 0 to get all of them.
+func (c *Context) SlowestCommands(n int) []CommandTiming {
+	timings := c.timings.list()
+	sort.SliceStable(timings, func(i, j int) bool {
+		return timings[i].Duration > timings[j].Duration
+	})
+	if n >= 0 && n < len(timings) {
+		timings = timings[:n]
+	}
+	return timings
+}
+
+// WriteTimingReport writes a summary of the n slowest commands executed in
+// this Context to w.
+func (c *Context) WriteTimingReport(w io.Writer, n int) error {
+	timings := c.timings.list()
+	var total time.Duration
+	for _, timing := range timings {
+		total += timing.Duration
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%d commands, total time %s\n", len(timings), total.Round(time.Millisecond))
+	fmt.Fprintln(tw, "DURATION\tEXIT CODE\tCOMMAND")
+	for _, timing := range c.SlowestCommands(n) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", timing.Duration.Round(time.Millisecond), timing.ExitCode, timing.Command)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,62 @@
+package script
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessTiming(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	before := time.Now()
+	pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin sleep"))
+	assert.Nil(err)
+
+	assert.False(pr.StartTime().Before(before))
+	assert.True(pr.EndTime().After(pr.StartTime()))
+	assert.GreaterOrEqual(pr.Duration(), 50*time.Millisecond)
+	assert.GreaterOrEqual(pr.UserTime(), time.Duration(0))
+	assert.GreaterOrEqual(pr.SystemTime(), time.Duration(0))
+	assert.Greater(pr.MaxRSS(), int64(0))
+}
+
+func TestProcessTimingDetached(t *testing.T) {
+	sc := processContext()
+	setOutputBuffers(sc)
+	pr, err := sc.ExecuteDetachedFullySilent(LocalCommandFrom("./bin sleep"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), pr.Duration())
+	sc.WaitCmd(pr)
+	assert.GreaterOrEqual(t, pr.Duration(), 50*time.Millisecond)
+}
+
+func TestSlowestCommands(t *testing.T) {
+	assert := assert.New(t)
+
+	sc := processContext()
+	sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	sc.Clone().ExecuteFullySilent(LocalCommandFrom("./bin sleep"))
+	sc.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+
+	assert.Len(sc.CommandTimings(), 3)
+	assert.Equal("./bin basic-output", sc.CommandTimings()[0].Command)
+
+	slowest := sc.SlowestCommands(1)
+	assert.Len(slowest, 1)
+	assert.Equal("./bin sleep", slowest[0].Command)
+	assert.Equal(sc.WorkingDir(), slowest[0].Dir)
+	assert.Len(sc.SlowestCommands(-1), 3)
+
+	var b bytes.Buffer
+	err := sc.WriteTimingReport(&b, 2)
+	assert.Nil(err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(lines, 4)
+	assert.True(strings.HasPrefix(lines[0], "3 commands, total time "))
+	assert.Contains(lines[2], "./bin sleep")
+}
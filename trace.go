@@ -0,0 +1,205 @@
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations reported to a Tracer.
+const (
+	TraceExecStart      = "exec-start"
+	TraceExec           = "exec"
+	TraceCopyFile       = "copy-file"
+	TraceCopyDir        = "copy-dir"
+	TraceMoveFile       = "move-file"
+	TraceMoveDir        = "move-dir"
+	TraceEnsureDir      = "ensure-dir"
+	TraceTempFile       = "temp-file"
+	TraceTempDir        = "temp-dir"
+	TraceReplaceInFile  = "replace-in-file"
+	TraceEditLines      = "edit-lines"
+	TraceRenderTemplate = "render-template"
+	TraceWriteFile      = "write-file"
+	TraceResolveSymlink = "resolve-symlinks"
+)
+
+// TraceEvent describes a single operation executed in a Context. Secrets
+// registered with the Context are masked in all fields.
+type TraceEvent struct {
+	Time time.Time
+	Op   string
+	// Command, Dir, Env and ExitCode are only set for command executions.
+	// Env contains only the variables set in the Context, not the full
+	// environment of the process.
+	Command  string
+	Dir      string
+	Env      []string
+	ExitCode int
+	// Paths are the files and directories a filesystem operation worked on.
+	Paths    []string
+	Duration time.Duration
+	Err      error
+}
+
+// Tracer receives an event for every operation executed in a Context.
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// TracerFunc is an adapter to use an ordinary function as Tracer.
+type TracerFunc func(event TraceEvent)
+
+// Trace calls f(event).
+func (f TracerFunc) Trace(event TraceEvent) {
+	f(event)
+}
+
+// SetTracer sets the Tracer that is informed about every operation executed in
+// this Context. Use nil to disable tracing.
+func (c *Context) SetTracer(tracer Tracer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tracer = tracer
+}
+
+// Tracer returns the Tracer of this Context or nil if there is none.
+func (c *Context) Tracer() Tracer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tracer
+}
+
+func (c *Context) trace(event TraceEvent) {
+	tracer := c.Tracer()
+	if tracer == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Err = c.maskError(event.Err)
+	for i, path := range event.Paths {
+		event.Paths[i] = c.MaskSecrets(path)
+	}
+	tracer.Trace(event)
+}
+
+// traceOp reports a finished filesystem operation. It is meant to be deferred
+// at the very beginning of the operation:
+//
+//	defer c.traceOp(TraceCopyFile, time.Now(), &err, from, to)
+func (c *Context) traceOp(op string, start time.Time, err *error, paths ...string) {
+	c.trace(TraceEvent{
+		Time:     start,
+		Op:       op,
+		Paths:    paths,
+		Duration: time.Since(start),
+		Err:      *err,
+	})
+}
+
+// NewShellTracer returns a Tracer writing every operation to w in the style of
+// `set -x` in bash: commands are printed as "+ cmd args" before they are run.
+// Commands that fail are printed again with their error or exit code.
+func NewShellTracer(w io.Writer) Tracer {
+	var mu sync.Mutex
+	return TracerFunc(func(event TraceEvent) {
+		var line string
+		switch event.Op {
+		case TraceExecStart:
+			line = "+ " + event.Command
+		case TraceExec:
+			// the command has been printed on start already
+			switch {
+			case event.Err != nil:
+				line = fmt.Sprintf("+ %s: %v", event.Command, event.Err)
+			case event.ExitCode != 0:
+				line = fmt.Sprintf("+ %s: exit code %d", event.Command, event.ExitCode)
+			default:
+				return
+			}
+		default:
+			line = "+ " + strings.Join(append([]string{event.Op}, event.Paths...), " ")
+			if event.Err != nil {
+				line += fmt.Sprintf(": %v", event.Err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(w, line)
+	})
+}
+
+type jsonTraceEvent struct {
+	Time       time.Time `json:"time"`
+	Op         string    `json:"op"`
+	Command    string    `json:"command,omitempty"`
+	Dir        string    `json:"dir,omitempty"`
+	Env        []string  `json:"env,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Paths      []string  `json:"paths,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewJSONTracer returns a Tracer writing every event as a single line of JSON
+// to w, which is suitable for log files of CI systems.
+func NewJSONTracer(w io.Writer) Tracer {
+	var mu sync.Mutex
+	return TracerFunc(func(event TraceEvent) {
+		e := jsonTraceEvent{
+			Time:       event.Time,
+			Op:         event.Op,
+			Command:    event.Command,
+			Dir:        event.Dir,
+			Env:        event.Env,
+			Paths:      event.Paths,
+			DurationMs: float64(event.Duration) / float64(time.Millisecond),
+		}
+		if event.Op == TraceExec {
+			e.ExitCode = &event.ExitCode
+		}
+		if event.Err != nil {
+			e.Error = event.Err.Error()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(e)
+	})
+}
+
+// NewSlogTracer returns a Tracer logging every event to the given logger.
+// Failed operations are logged with level error, all others with level info.
+func NewSlogTracer(logger *slog.Logger) Tracer {
+	return TracerFunc(func(event TraceEvent) {
+		level := slog.LevelInfo
+		attrs := make([]slog.Attr, 0, 8)
+		if event.Command != "" {
+			attrs = append(attrs,
+				slog.String("command", event.Command),
+				slog.String("dir", event.Dir),
+				slog.Any("env", event.Env),
+			)
+		}
+		if event.Op == TraceExec {
+			attrs = append(attrs, slog.Int("exit_code", event.ExitCode))
+		}
+		if len(event.Paths) > 0 {
+			attrs = append(attrs, slog.Any("paths", event.Paths))
+		}
+		if event.Op != TraceExecStart {
+			attrs = append(attrs, slog.Duration("duration", event.Duration))
+		}
+		if event.Err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", event.Err.Error()))
+		}
+		logger.LogAttrs(context.Background(), level, event.Op, attrs...)
+	})
+}
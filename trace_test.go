@@ -0,0 +1,95 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellTracer(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	var b bytes.Buffer
+	sc := processContext()
+	setOutputBuffers(sc)
+	sc.SetTracer(NewShellTracer(&b))
+	sc.AddSecret("output")
+
+	_, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	assert.Equal(t, "+ ./bin basic-***\n", b.String())
+
+	b.Reset()
+	_, err = sc.ExecuteFullySilent(LocalCommandFrom(nonExistingBinary))
+	assert.NotNil(t, err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "+ "+nonExistingBinary, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "+ "+nonExistingBinary+": "))
+
+	b.Reset()
+	pr, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+	assert.Nil(t, err)
+	exitCode, _ := pr.ExitCode()
+	assert.Equal(t, fmt.Sprintf("+ ./bin exit-code-error\n+ ./bin exit-code-error: exit code %d\n", exitCode), b.String())
+}
+
+func TestJSONTracer(t *testing.T) {
+	t.Cleanup(forgetSecrets)
+	var b bytes.Buffer
+	sc := processContext()
+	sc.SetTracer(NewJSONTracer(&b))
+	sc.SetSecretEnv("TOKEN", "abc")
+
+	_, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin exit-code-error"))
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	assert.Len(t, lines, 2)
+	var event map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, TraceExec, event["op"])
+	assert.Equal(t, "./bin exit-code-error", event["command"])
+	assert.Equal(t, sc.WorkingDir(), event["dir"])
+	assert.Equal(t, []interface{}{"TOKEN=***"}, event["env"])
+	assert.Equal(t, float64(28), event["exit_code"])
+}
+
+func TestSlogTracer(t *testing.T) {
+	var b bytes.Buffer
+	sc := processContext()
+	sc.SetTracer(NewSlogTracer(slog.New(slog.NewTextHandler(&b, nil))))
+
+	_, err := sc.ExecuteFullySilent(LocalCommandFrom("./bin basic-output"))
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), "msg=exec-start")
+	assert.Contains(t, b.String(), "msg=exec ")
+	assert.Contains(t, b.String(), "exit_code=0")
+}
+
+func TestTraceFilesystem(t *testing.T) {
+	events := make([]TraceEvent, 0)
+	sc := NewContext()
+	sc.fs = afero.NewMemMapFs()
+	sc.SetTracer(TracerFunc(func(event TraceEvent) {
+		events = append(events, event)
+	}))
+
+	makeFile(sc, "/a/file", "content")
+	sc.CopyFile("/a/file", "/a/copy")
+	sc.MoveDir("/a", "/b")
+	sc.ReplaceInFile("/b/not-existing", "a", "b")
+
+	assert.Len(t, events, 3)
+	assert.Equal(t, TraceCopyFile, events[0].Op)
+	assert.Equal(t, []string{"/a/file", "/a/copy"}, events[0].Paths)
+	assert.Nil(t, events[0].Err)
+	assert.Equal(t, TraceMoveDir, events[1].Op)
+	assert.Equal(t, TraceReplaceInFile, events[2].Op)
+	assert.NotNil(t, events[2].Err)
+}
@@ -0,0 +1,191 @@
+package script
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// geteuid is replaced in tests to cover running as a regular user.
+var geteuid = os.Geteuid
+
+// ElevationError is returned if a command can not be run as the requested user.
+type ElevationError struct {
+	User   string
+	Reason string
+}
+
+func (e ElevationError) Error() string {
+	if e.User == "" {
+		return fmt.Sprintf("unable to elevate privileges: %s", e.Reason)
+	}
+	return fmt.Sprintf("unable to run as user `%s`: %s", e.User, e.Reason)
+}
+
+// AsUser returns a copy of this Context running all commands as the given
+// user. If this program is run by root, commands are started with the user's
+// credentials directly, otherwise they are wrapped using non-interactive sudo
+// ("sudo -n -u user"), so sudo must be configured to allow this without a
+// password. The environment variables set in the Context are passed using
+// sudo's --preserve-env which must be permitted by the sudo configuration.
+// sudo is probed once per user for this Context and its clones, if it refuses
+// an ElevationError with its message is returned instead of running commands.
+func (c *Context) AsUser(name string) *Context {
+	clone := c.Clone()
+	clone.mu.Lock()
+	defer clone.mu.Unlock()
+	clone.user = name
+	return clone
+}
+
+// User returns the name of the user commands are run as or an empty string if
+// they are run as the current user.
+func (c *Context) User() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.user
+}
+
+// RequireRoot makes sure the program is run by root. Otherwise the current
+// binary is executed again using sudo with the same arguments, replacing the
+// current process. An error is returned only if that is impossible.
+func (c *Context) RequireRoot() error {
+	if c.IsUserRoot() {
+		return nil
+	}
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return &ElevationError{User: "root", Reason: "sudo is not available"}
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return &ElevationError{User: "root", Reason: err.Error()}
+	}
+	args := append([]string{"sudo", "--", executable}, os.Args[1:]...)
+	err = syscall.Exec(sudo, args, os.Environ())
+	return &ElevationError{User: "root", Reason: err.Error()}
+}
+
+// setCommandUser prepares cmd to be run as the given user.
+func setCommandUser(cmd *exec.Cmd, name string, env []string, probes *sudoProbes) error {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return &ElevationError{User: name, Reason: err.Error()}
+	}
+	current, err := user.Current()
+	if err == nil && current.Uid == u.Uid {
+		return nil
+	}
+
+	if geteuid() == 0 {
+		credential, err := userCredential(u)
+		if err != nil {
+			return &ElevationError{User: name, Reason: err.Error()}
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = credential
+		return nil
+	}
+
+	sudo, err := exec.LookPath("sudo")
+	if err != nil {
+		return &ElevationError{User: name, Reason: "not running as root and sudo is not available"}
+	}
+	err = probes.probe(sudo, name, env, cmd.Env)
+	if err != nil {
+		return err
+	}
+	cmd.Path = sudo
+	cmd.Args = sudoArgs(name, env, cmd.Args)
+	return nil
+}
+
+// sudoProbes caches the results of probeSudo by user and preserved
+// environment variables.
+type sudoProbes struct {
+	mu      sync.Mutex
+	results map[string]error
+}
+
+func (p *sudoProbes) probe(sudo, name string, env, cmdEnv []string) error {
+	key := strings.Join(sudoArgs(name, env, nil), " ")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err, ok := p.results[key]; ok {
+		return err
+	}
+	err := probeSudo(sudo, name, env, cmdEnv)
+	if p.results == nil {
+		p.results = make(map[string]error)
+	}
+	p.results[key] = err
+	return err
+}
+
+// probeSudo checks that sudo runs a command as the given user without asking
+// for a password and with the environment preserved, so failures of sudo are
+// not mistaken for failures of the command itself.
+func probeSudo(sudo, name string, env, cmdEnv []string) error {
+	args := sudoArgs(name, env, []string{"true"})
+	probe := exec.Command(sudo, args[1:]...)
+	probe.Env = cmdEnv
+	var stderr bytes.Buffer
+	probe.Stderr = &stderr
+	err := probe.Run()
+	if err == nil {
+		return nil
+	}
+	reason := strings.TrimSpace(stderr.String())
+	if reason == "" {
+		reason = err.Error()
+	}
+	return &ElevationError{User: name, Reason: reason}
+}
+
+func userCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	credential := &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return credential, nil
+	}
+	for _, g := range groupIDs {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err == nil {
+			credential.Groups = append(credential.Groups, uint32(id))
+		}
+	}
+	return credential, nil
+}
+
+// sudoArgs wraps a command line to be run by the given user using sudo.
+func sudoArgs(name string, env []string, args []string) []string {
+	result := []string{"sudo", "-n", "-u", name}
+	if len(env) > 0 {
+		keys := make([]string, 0, len(env))
+		for _, e := range env {
+			keys = append(keys, strings.SplitN(e, "=", 2)[0])
+		}
+		result = append(result, "--preserve-env="+strings.Join(keys, ","))
+	}
+	result = append(result, "--")
+	return append(result, args...)
+}
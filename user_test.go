@@ -0,0 +1,93 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running commands as another user without sudo requires root")
+	}
+	sc := NewContext()
+	sc.SetWorkingDir(os.TempDir())
+
+	pr, err := sc.AsUser("nobody").ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.Nil(t, err)
+	assert.Equal(t, "nobody", pr.TrimmedOutput())
+
+	pr, err = sc.Execute(CommandConfig{User: "nobody"}, LocalCommandFrom("id -un"))
+	assert.Nil(t, err)
+	assert.Equal(t, "nobody", pr.TrimmedOutput())
+
+	pr, err = sc.ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.Nil(t, err)
+	assert.Equal(t, "root", pr.TrimmedOutput())
+}
+
+func TestAsUserUnknown(t *testing.T) {
+	sc := NewContext()
+	child := sc.AsUser("user-does-not-exist")
+	assert.Equal(t, "user-does-not-exist", child.User())
+	assert.Equal(t, "", sc.User())
+
+	_, err := child.ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.IsType(t, &ElevationError{}, err)
+	assert.Contains(t, err.Error(), "user-does-not-exist")
+}
+
+func TestAsUserSudoRefused(t *testing.T) {
+	// pretend not to be root and use a sudo that requires a password
+	geteuid = func() int { return 1000 }
+	defer func() { geteuid = os.Geteuid }()
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\necho 'sudo: a password is required' >&2\nexit 1\n"), 0755)
+	t.Setenv("PATH", bin)
+
+	sc := NewContext()
+	sc.SetWorkingDir(os.TempDir())
+	_, err := sc.AsUser("nobody").ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.Equal(t, &ElevationError{User: "nobody", Reason: "sudo: a password is required"}, err)
+
+	// sudo failing without a message, the result is cached per Context
+	os.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+	sc = NewContext()
+	sc.SetWorkingDir(os.TempDir())
+	_, err = sc.AsUser("nobody").ExecuteFullySilent(LocalCommandFrom("id -un"))
+	assert.Equal(t, &ElevationError{User: "nobody", Reason: "exit status 1"}, err)
+}
+
+func TestAsUserSudoProbedOnce(t *testing.T) {
+	geteuid = func() int { return 1000 }
+	defer func() { geteuid = os.Geteuid }()
+	bin := t.TempDir()
+	log := filepath.Join(bin, "calls")
+	os.WriteFile(filepath.Join(bin, "sudo"), []byte("#!/bin/sh\nprintf '%s\\n' \"$*\" >> "+log+"\n"), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	sc := NewContext()
+	sc.SetWorkingDir(os.TempDir())
+	for i := 0; i < 2; i++ {
+		pr, err := sc.AsUser("nobody").ExecuteFullySilent(LocalCommandFrom("id -un"))
+		assert.Nil(t, err)
+		assert.True(t, pr.Successful())
+	}
+	calls, _ := os.ReadFile(log)
+	assert.Equal(t, "-n -u nobody -- true\n-n -u nobody -- id -un\n-n -u nobody -- id -un\n", string(calls))
+}
+
+func TestSudoArgs(t *testing.T) {
+	assert.Equal(t, []string{"sudo", "-n", "-u", "www", "--", "ls", "-la"}, sudoArgs("www", nil, []string{"ls", "-la"}))
+	assert.Equal(t, []string{"sudo", "-n", "-u", "www", "--preserve-env=A,B", "--", "ls"}, sudoArgs("www", []string{"A=1", "B=x=y"}, []string{"ls"}))
+}
+
+func TestRequireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("would re-execute the test binary using sudo")
+	}
+	sc := NewContext()
+	assert.Nil(t, sc.RequireRoot())
+}